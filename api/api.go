@@ -1,38 +1,58 @@
 package api
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/krostar/nebulo-golib/log"
-	ghttperror "github.com/krostar/nebulo-golib/router/httperror"
-	"github.com/krostar/nebulo-golib/tools/cert"
 
 	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/identity"
+	"github.com/krostar/nebulo-client-desktop/user"
 )
 
 // Server store informations to make the communication
 // with the API server easier
 type Server struct {
-	Client    string
+	Client string
+
+	// replaced when the configuration is reloaded while requests are made,
+	// only accessed with mutex held, see url and client
+	mutex     sync.RWMutex
 	BaseURL   string
 	TLSConfig *tls.Config
 	HTTP      *http.Client
+
+	breaker breaker // track the server reachability
+	session session // track the authentication of the logged user
+
+	capabilities map[string]bool // features the server support, see Supports
+
+	transportMutex sync.Mutex       // serialize the changes of the transport and the middlewares
+	transport      *http.Transport  // long-lived, rebuilt when the tls material change
+	transportKey   string           // hash of what the transport has been built from
+	metrics        transportMetrics // usage of the connections
+
+	middlewares []Middleware // wrap the transport, see Use
+	trace       *Trace       // requests recorded for bug reports, if enabled
 }
 
 // API is the current configuration to contact the api server
 var API *Server
 
 const (
-	CONTENT_TYPE_JSON = "application/json"
-	CONTENT_TYPE_PEM  = "application/x-pem-file"
+	CONTENT_TYPE_JSON         = "application/json"
+	CONTENT_TYPE_PEM          = "application/x-pem-file"
+	CONTENT_TYPE_OCTET_STREAM = "application/octet-stream"
 )
 
 func createTLSConfig(tlsOptions *config.TLSOptions) (config *tls.Config, err error) {
@@ -44,13 +64,14 @@ func createTLSConfig(tlsOptions *config.TLSOptions) (config *tls.Config, err err
 	clientCAPool.AppendCertsFromPEM(clientCAFile)
 
 	config = &tls.Config{
-		MinVersion: tls.VersionTLS12,
-		RootCAs:    clientCAPool,
+		MinVersion:            tls.VersionTLS12,
+		RootCAs:               clientCAPool,
+		VerifyPeerCertificate: verifyPins,
 	}
 
 	// if we don't have client cert or private key, nothing to do, call who need auth will failed
-	if tlsOptions.Cert != "" && tlsOptions.Key != "" {
-		crt, err := cert.TLSCertificateFromFiles(tlsOptions.Cert, tlsOptions.Key, []byte(tlsOptions.KeyPassword))
+	if source := user.IdentitySource(tlsOptions); source.Defined() {
+		crt, err := identityCertificate(source)
 		if err != nil {
 			log.Warningf("unable to load tls key pair: %v", err)
 		} else {
@@ -61,85 +82,210 @@ func createTLSConfig(tlsOptions *config.TLSOptions) (config *tls.Config, err err
 	return config, nil
 }
 
-// Request add things every requests need, do the request, check the status code and return the response
-func (api *Server) Request(request *http.Request, expectedStatus int) (response *http.Response, err error) {
+// identityCertificate load an identity as a tls certificate
+func identityCertificate(source identity.Source) (crt *tls.Certificate, err error) {
+	id, err := identity.Load(source)
+	if err != nil {
+		return nil, err
+	}
+	return id.TLSCertificate()
+}
+
+// Request add things every requests need, do the request, check the status code and return the response;
+// idempotent requests are retried while the server is unreachable, unless the server is offline
+func (api *Server) Request(ctx context.Context, request *http.Request, expectedStatus int) (response *http.Response, err error) {
+	request = request.WithContext(httptrace.WithClientTrace(ctx, api.metrics.trace()))
 	request.Header.Set("User-Agent", api.Client)
 
-	response, err = api.HTTP.Do(request)
+	for attempt := 1; ; attempt++ {
+		if err = api.breaker.allow(); err != nil {
+			return nil, err
+		}
+		response, err = api.client().Do(request)
+		api.metrics.response(response)
+		if ctx.Err() != nil { // cancelled by the caller, the server is not to blame
+			if response != nil {
+				response.Body.Close() // nolint: errcheck
+			}
+			api.breaker.abort()
+			return nil, fmt.Errorf("unable to do request: %w", ctx.Err())
+		}
+
+		failed := unreachable(response, err)
+		// too many requests means the server is up
+		if api.breaker.record(failed && (response == nil || response.StatusCode != http.StatusTooManyRequests)) {
+			go api.probe()
+		}
+		if !failed || !retryable(request) || attempt >= retryAttempts {
+			break
+		}
+
+		delay := retryDelay(attempt)
+		if after := retryAfter(response); after > 0 {
+			delay = after
+		}
+		if response != nil {
+			response.Body.Close() // nolint: errcheck
+			log.Infof("%s %s: status %d, retrying in %s", request.Method, request.URL.Path, response.StatusCode, delay)
+		} else {
+			log.Infof("%s %s: %v, retrying in %s", request.Method, request.URL.Path, err, delay)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("unable to do request: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to do request: %v", err)
+		if identityRejected(err) {
+			err = fmt.Errorf("%w: %v", ErrUnauthorized, err)
+			api.sessionLost(err)
+		}
+		return nil, fmt.Errorf("unable to do request: %w", err)
 	}
 
-	// if response status doesnt match expected status, read the response, parse the error and return it
+	// if response status doesnt match expected status, read the response and return the server error
 	if response.StatusCode != expectedStatus {
-		err = fmt.Errorf("bad status code: expected %d receive %d", expectedStatus, response.StatusCode)
 		defer response.Body.Close() // nolint: errcheck
 		raw, errRead := ioutil.ReadAll(response.Body)
-		if errRead != nil {
-			err = fmt.Errorf("%v; unable to read response data: %v", err, errRead)
-			return nil, err
+		apiErr := newError(response.StatusCode, expectedStatus, raw)
+		if apiErr.Is(ErrUnauthorized) {
+			api.sessionLost(apiErr)
 		}
-		er := &ghttperror.HTTPErrors{}
-		if errJSON := json.Unmarshal(raw, er); errJSON != nil {
-			return nil, fmt.Errorf("%v; unable to parse response data: %v", err, errJSON)
+		if errRead != nil {
+			return nil, fmt.Errorf("%w; unable to read response data: %v", apiErr, errRead)
 		}
-		return nil, fmt.Errorf("%v; %s", err, er.Error())
+		return nil, apiErr
 	}
 	return response, nil
 }
 
+// url return the url of an endpoint of the server
+func (api *Server) url(endpoint string) string {
+	api.mutex.RLock()
+	defer api.mutex.RUnlock()
+	return fmt.Sprintf("%s/%s", api.BaseURL, endpoint)
+}
+
+// client return the http client to contact the server with
+func (api *Server) client() *http.Client {
+	api.mutex.RLock()
+	defer api.mutex.RUnlock()
+	return api.HTTP
+}
+
 // Get create and send a GET request and return the response
-func (api *Server) Get(endpoint string, expectedStatus int, queryParams url.Values) (response *http.Response, err error) {
+func (api *Server) Get(ctx context.Context, endpoint string, expectedStatus int, queryParams url.Values) (response *http.Response, err error) {
 	params := ""
 	if queryParams != nil {
 		params = "?" + queryParams.Encode()
 	}
-	request, err := http.NewRequest("GET", fmt.Sprintf("%s/%s%s", api.BaseURL, endpoint, params), nil)
+	request, err := http.NewRequest("GET", api.url(endpoint)+params, nil)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create request: %v", err)
+		return nil, fmt.Errorf("unable to create request: %w", err)
 	}
-	return api.Request(request, expectedStatus)
+	return api.Request(ctx, request, expectedStatus)
 }
 
 // Post create and send a POST request and return the response
-func (api *Server) Post(endpoint string, expectedStatus int, contentType string, body io.Reader) (response *http.Response, err error) {
-	request, err := http.NewRequest("POST", fmt.Sprintf("%s/%s", api.BaseURL, endpoint), body)
+func (api *Server) Post(ctx context.Context, endpoint string, expectedStatus int, contentType string, body io.Reader) (response *http.Response, err error) {
+	request, err := http.NewRequest("POST", api.url(endpoint), body)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create request: %v", err)
+		return nil, fmt.Errorf("unable to create request: %w", err)
 	}
 	request.Header.Set("Content-Type", contentType)
-	return api.Request(request, expectedStatus)
+	return api.Request(ctx, request, expectedStatus)
 }
 
 // Initialize create a new Server{} base url and certificate configuration
-func Initialize(version string, baseurl string, tlsOptions *config.TLSOptions) (serverVersion *VersionResponse, err error) {
+func Initialize(ctx context.Context, version string, baseurl string, tlsOptions *config.TLSOptions) (serverVersion *VersionResponse, err error) {
 	api := &Server{
 		Client:  fmt.Sprintf("nebulo-desktop/%s", version),
 		BaseURL: baseurl,
 	}
+	api.Use(LoggingMiddleware)
+	if config.Current().Run.TraceFile != "" {
+		api.trace = NewTrace(api.Client)
+		api.Use(api.trace.Middleware)
+	}
+	if err = api.useFixtures(&config.Current().Run.Fixtures); err != nil {
+		return nil, err
+	}
 	if err = changeTLSOptions(api, tlsOptions); err != nil {
 		return nil, err
 	}
 
-	serverVersion, err = api.Version()
+	serverVersion, err = api.Version(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("unable to communicate with server %q: %v", baseurl, err)
 	}
+	if err = api.negotiate(serverVersion); err != nil {
+		return nil, fmt.Errorf("unable to use server %q: %w", baseurl, err)
+	}
 
 	API = api
+	config.Subscribe(api.onConfigReloaded)
 	return serverVersion, nil
 }
 
+// onConfigReloaded apply the reloaded base url and transport options, the
+// transport is only rebuilt when its options changed and a user is logged
+func (api *Server) onConfigReloaded(previous config.Options) {
+	if baseURL := config.Current().Run.BaseURL; baseURL != previous.Run.BaseURL {
+		log.Warningf("api server changed from %q to %q, its capabilities are the ones of the previous server until restart", previous.Run.BaseURL, baseURL)
+		api.mutex.Lock()
+		api.BaseURL = baseURL
+		api.mutex.Unlock()
+	}
+	// without logged user the transport is kept without identity, see Logout
+	if !user.IsLogged() {
+		return
+	}
+	if err := changeTLSOptions(api, &config.Current().Run.TLS); err != nil {
+		log.Errorf("unable to apply reloaded transport configuration: %v, the current one is kept", err)
+	}
+}
+
+// changeTLSOptions build the transport used to contact the server, the
+// current one and its connections are kept when nothing changed
 func changeTLSOptions(api *Server, tlsOptions *config.TLSOptions) (err error) {
+	api.transportMutex.Lock()
+	defer api.transportMutex.Unlock()
+
+	key := transportKey(tlsOptions)
+	if api.transport != nil && key == api.transportKey {
+		return nil
+	}
+
 	tlsConfig, err := createTLSConfig(tlsOptions)
 	if err != nil {
-		return fmt.Errorf("tls configuration error: %v", err)
+		return fmt.Errorf("tls configuration error: %w", err)
 	}
 
+	proxy, err := proxyFunc(&config.Current().Run.Proxy)
+	if err != nil {
+		return fmt.Errorf("proxy configuration error: %w", err)
+	}
+
+	previous := api.transport
+	api.transport = newTransport(tlsConfig, proxy)
+	api.transportKey = key
+	api.mutex.Lock()
 	api.TLSConfig = tlsConfig
-	api.HTTP = &http.Client{
-		Timeout:   30 * time.Second,
-		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	// deadlines are set on each call context, depending on the call
+	api.HTTP = &http.Client{Transport: api.chain(api.transport)}
+	api.mutex.Unlock()
+	if previous != nil {
+		log.Debugln("tls material changed, closing the connections to the server")
+		previous.CloseIdleConnections()
 	}
 	return nil
 }
+
+// WriteTrace write the requests recorded since the start to the trace file, if enabled
+func (api *Server) WriteTrace() (err error) {
+	if api.trace == nil {
+		return nil
+	}
+	return api.trace.WriteFile(config.Current().Run.TraceFile)
+}
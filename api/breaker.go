@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/config"
+)
+
+const (
+	// breakerThreshold is the number of consecutive failures opening the circuit
+	breakerThreshold = 3
+	// breakerCooldownMin is the delay before the first attempt to close the circuit
+	breakerCooldownMin = 5 * time.Second
+	// breakerCooldownMax is the maximum delay between two attempts to close the circuit
+	breakerCooldownMax = 2 * time.Minute
+)
+
+// ErrOffline is returned without contacting the server while it's considered unreachable
+var ErrOffline = errors.New("server unreachable, waiting for it to come back")
+
+// breaker is a circuit breaker: after too many consecutive failures the
+// server is considered offline and requests fail immediately, until a
+// probe succeed after a growing cooldown
+type breaker struct {
+	mutex     sync.Mutex
+	failures  int
+	open      bool
+	probing   bool // a request is allowed to test the server while open
+	cooldown  time.Duration
+	openUntil time.Time
+
+	onChange func(online bool)
+}
+
+// allow return ErrOffline when the circuit is open and no probe can be done yet
+func (b *breaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.open {
+		return nil
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return ErrOffline
+	}
+	b.probing = true
+	return nil
+}
+
+// record the result of a request, failed is true when the server could not be reached
+func (b *breaker) record(failed bool) (tripped bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		if b.open {
+			b.open = false
+			b.cooldown = 0
+			log.Infoln("server is reachable again")
+			b.notify(true)
+		}
+		return false
+	}
+
+	b.failures++
+	if !b.open && b.failures < breakerThreshold {
+		return false
+	}
+	// open the circuit, or keep it open for longer after a failed probe
+	switch {
+	case b.cooldown == 0:
+		b.cooldown = breakerCooldownMin
+	case b.cooldown < breakerCooldownMax:
+		b.cooldown *= 2
+		if b.cooldown > breakerCooldownMax {
+			b.cooldown = breakerCooldownMax
+		}
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+	if !b.open {
+		b.open = true
+		log.Warningf("server unreachable after %d attempts, going offline", b.failures)
+		b.notify(false)
+		return true
+	}
+	return false
+}
+
+// abort forget a request allowed by allow whose result is unknown
+func (b *breaker) abort() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+// until return the time to wait before the next probe
+func (b *breaker) until() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return time.Until(b.openUntil)
+}
+
+func (b *breaker) isOpen() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.open
+}
+
+func (b *breaker) notify(online bool) {
+	if b.onChange != nil {
+		go b.onChange(online)
+	}
+}
+
+// OnOnlineChange register the function called, from any goroutine, when
+// the server become unreachable or reachable again
+func (api *Server) OnOnlineChange(onChange func(online bool)) {
+	api.breaker.mutex.Lock()
+	defer api.breaker.mutex.Unlock()
+	api.breaker.onChange = onChange
+}
+
+// Online return whether the server is considered reachable
+func (api *Server) Online() bool {
+	return !api.breaker.isOpen()
+}
+
+// probe call the server until it's reachable again, so the
+// offline state end even if nothing else is requested
+func (api *Server) probe() {
+	for api.breaker.isOpen() {
+		// the cooldown may be over while another request is probing, wait
+		// for its result instead of spinning
+		wait := api.breaker.until()
+		if wait <= 0 {
+			wait = breakerCooldownMin
+		}
+		time.Sleep(wait)
+		ctx, cancel := context.WithTimeout(context.Background(), config.Current().Run.Timeouts.DefaultTimeout())
+		if _, err := api.Version(ctx); err != nil {
+			log.Debugf("server still unreachable: %v", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/channel"
+	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/ratchet"
+	"github.com/krostar/nebulo-client-desktop/user"
+)
+
+type chainKeyInfos struct {
+	Receiver string           `json:"receiver_pkey"`
+	Key      *ratchet.Wrapped `json:"key"`
+}
+
+type channelChainCreateRequest struct {
+	ChainID   string          `json:"chain_id"`
+	Members   []string        `json:"members"`
+	Keys      []chainKeyInfos `json:"keys"`
+	Signature []byte          `json:"signature"` // of chainSignedData, by the sender
+}
+
+// channelChainResponse is a chain seed wrapped for the logged user
+type channelChainResponse struct {
+	ChainID   string         `json:"chain_id"`
+	Sender    string         `json:"sender_fingerprint"`
+	Members   []string       `json:"members"`
+	Created   time.Time      `json:"created"`
+	Key       *chainKeyInfos `json:"key"`
+	Signature []byte         `json:"signature"`
+}
+
+// ChannelChainCreate wrap the seed of a new sending chain with the prekey of every member and send it,
+// the prekeys not signed by their member are refused as the server could have replaced them
+func (api *Server) ChannelChainCreate(ctx context.Context, members []*user.User, c *ratchet.Chain, seed []byte) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.DefaultTimeout())
+	defer cancel()
+
+	// members only accept chains signed by one of them, the server can't forge one
+	pKey, err := user.PrivateKey()
+	if err != nil {
+		return err
+	}
+	signature, err := pKey.Sign(chainSignedData(c.ChannelName, c.ID, c.Sender, c.Members, seed))
+	if err != nil {
+		return fmt.Errorf("unable to sign chain: %w", err)
+	}
+
+	request := &channelChainCreateRequest{ChainID: c.ID, Members: c.Members, Signature: signature}
+	for _, member := range members {
+		if err = verifyPrekey(member); err != nil {
+			return fmt.Errorf("unable to wrap chain for %q: %w", member.KeyFingerprint, err)
+		}
+		wrapped, err := ratchet.Wrap(seed, member.Prekey, chainAdditionalData(c.ChannelName, c.ID, c.Sender))
+		if err != nil {
+			return fmt.Errorf("unable to wrap chain for %q: %v", member.KeyFingerprint, err)
+		}
+		request.Keys = append(request.Keys, chainKeyInfos{
+			Receiver: member.PublicKeyDerBase64,
+			Key:      wrapped,
+		})
+	}
+
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("unable to marshal json: %w", err)
+	}
+
+	_, err = api.Post(ctx, fmt.Sprintf("chan/%s/chains", url.QueryEscape(c.ChannelName)), http.StatusCreated, CONTENT_TYPE_JSON, bytes.NewReader(requestBody))
+	if err != nil {
+		return fmt.Errorf("unable to get response: %w", err)
+	}
+	return nil
+}
+
+// ChannelChainList fetch the chains of a channel and store the ones wrapped for the logged user
+func (api *Server) ChannelChainList(ctx context.Context, channelName string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.DefaultTimeout())
+	defer cancel()
+
+	response, err := api.Get(ctx, fmt.Sprintf("chan/%s/chains", url.QueryEscape(channelName)), http.StatusOK, nil)
+	if err != nil {
+		return fmt.Errorf("unable to get response: %w", err)
+	}
+	defer response.Body.Close() // nolint: errcheck
+	raw, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read response data: %w", err)
+	}
+
+	list := []*channelChainResponse{}
+	if err = json.Unmarshal(raw, &list); err != nil {
+		return fmt.Errorf("unable to parse response data: %w", err)
+	}
+
+	for _, ccr := range list {
+		if ccr.Key == nil || ccr.Key.Receiver != user.Logged.PublicKeyDerBase64 || ratchet.HasReceivingChain(ccr.ChainID) {
+			continue
+		}
+		seed, err := ratchet.Unwrap(ccr.Key.Key, chainAdditionalData(channelName, ccr.ChainID, ccr.Sender))
+		if err != nil {
+			log.Warningf("unable to unwrap chain %q of channel %q: %v", ccr.ChainID, channelName, err)
+			continue
+		}
+		if err = verifyChain(channelName, ccr, seed); err != nil {
+			log.Warningf("ignoring chain %q of channel %q: %v", ccr.ChainID, channelName, err)
+			continue
+		}
+		err = ratchet.AddReceivingChain(&ratchet.Chain{
+			ID:          ccr.ChainID,
+			ChannelName: channelName,
+			Sender:      ccr.Sender,
+			Members:     ccr.Members,
+			Key:         seed,
+			Created:     ccr.Created,
+		})
+		if err != nil {
+			log.Warningf("unable to save chain %q of channel %q: %v", ccr.ChainID, channelName, err)
+		}
+	}
+	return nil
+}
+
+// ForwardSecrecy return whether messages sent to a channel are protected
+// by forward secrecy, and the reason why when they are not
+func ForwardSecrecy(c *channel.Channel) (active bool, reason string) {
+	if !config.Current().Run.ForwardSecrecy {
+		return false, "disabled"
+	}
+	missing := 0
+	for _, member := range channelRecipients(c) {
+		if verifyPrekey(member) != nil {
+			missing++
+		}
+	}
+	if missing > 0 {
+		return false, fmt.Sprintf("%d member(s) without a valid prekey", missing)
+	}
+	return true, ""
+}
+
+// channelSendingChain make sure a sending chain distributed to the current
+// members of the channel exists, a new chain is created otherwise
+func (api *Server) channelSendingChain(ctx context.Context, c *channel.Channel) (err error) {
+	members := channelRecipients(c)
+	fingerprints := []string{}
+	for _, member := range members {
+		fingerprints = append(fingerprints, member.KeyFingerprint)
+	}
+	if _, ok := ratchet.SendingChain(c.Name, fingerprints); ok {
+		return nil
+	}
+
+	log.Infof("creating a new sending chain for channel %q", c.Name)
+	chain, seed, err := ratchet.NewSendingChain(c.Name, user.Logged.KeyFingerprint, fingerprints)
+	if err != nil {
+		return fmt.Errorf("unable to create chain: %w", err)
+	}
+	if err = api.ChannelChainCreate(ctx, members, chain, seed); err != nil {
+		return fmt.Errorf("unable to distribute chain: %w", err)
+	}
+	return ratchet.UseSendingChain(chain, seed)
+}
+
+// chainAdditionalData bind a wrapped chain seed to its channel and sender
+func chainAdditionalData(channelName string, chainID string, sender string) []byte {
+	return []byte(fmt.Sprintf("%s:%s:%s", channelName, chainID, sender))
+}
+
+// verifyChain check the chain has been signed by its sender, who must be
+// a member of the channel and one of the members it's distributed to
+func verifyChain(channelName string, ccr *channelChainResponse, seed []byte) (err error) {
+	pubKey, err := senderPublicKey(channelName, ccr.Sender, ccr.Members)
+	if err != nil {
+		return err
+	}
+	sort.Strings(ccr.Members)
+	if err = pubKey.Verify(chainSignedData(channelName, ccr.ChainID, ccr.Sender, ccr.Members, seed), ccr.Signature); err != nil {
+		return fmt.Errorf("invalid signature of %q: %w", ccr.Sender, err)
+	}
+	return nil
+}
+
+// chainSignedData bind a chain to its channel, id, sender and members,
+// only a digest of the seed is signed
+func chainSignedData(channelName string, chainID string, sender string, members []string, seed []byte) []byte {
+	digest := sha256.Sum256(seed)
+	return []byte(fmt.Sprintf("nebulo chain:%s:%s:%s:%s:%x", channelName, chainID, sender, strings.Join(members, ","), digest))
+}
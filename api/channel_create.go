@@ -2,14 +2,14 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 
-	"github.com/krostar/nebulo-golib/log"
-
 	"github.com/krostar/nebulo-client-desktop/channel"
+	"github.com/krostar/nebulo-client-desktop/config"
 )
 
 type channelCreateRequest struct {
@@ -18,30 +18,31 @@ type channelCreateRequest struct {
 }
 
 // ChannelCreate return the wanted channel profile informations
-func (api *Server) ChannelCreate(name string, membersPublicKey []string) (c *channel.Channel, err error) {
-	log.Debugln("doing Channel Create call")
+func (api *Server) ChannelCreate(ctx context.Context, name string, membersPublicKey []string) (c *channel.Channel, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.DefaultTimeout())
+	defer cancel()
 
 	requestBody, err := json.Marshal(&channelCreateRequest{
 		Name:             name,
 		MembersPublicKey: membersPublicKey,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to marshal json: %v", err)
+		return nil, fmt.Errorf("unable to marshal json: %w", err)
 	}
 
-	response, err := api.Post("chan", http.StatusOK, CONTENT_TYPE_JSON, bytes.NewReader(requestBody))
+	response, err := api.Post(ctx, "chan", http.StatusOK, CONTENT_TYPE_JSON, bytes.NewReader(requestBody))
 	if err != nil {
-		return nil, fmt.Errorf("unable to get response: %v", err)
+		return nil, fmt.Errorf("unable to get response: %w", err)
 	}
 	defer response.Body.Close() // nolint: errcheck
 	raw, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read response data: %v", err)
+		return nil, fmt.Errorf("unable to read response data: %w", err)
 	}
 
 	crr := &channel.Channel{}
 	if err = json.Unmarshal(raw, crr); err != nil {
-		return nil, fmt.Errorf("unable to parse response data: %v", err)
+		return nil, fmt.Errorf("unable to parse response data: %w", err)
 	}
 
 	return crr, nil
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/channel"
+	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/identity"
+	"github.com/krostar/nebulo-client-desktop/user"
+)
+
+type channelKeyCreateRequest struct {
+	KeyID     string         `json:"key_id"`
+	Members   []string       `json:"members"`
+	Keys      []messageInfos `json:"keys"`
+	Signature []byte         `json:"signature"` // of channelKeySignedData, by the sender
+}
+
+// channelKeyResponse is a channel key wrapped for the logged user
+type channelKeyResponse struct {
+	KeyID     string        `json:"key_id"`
+	Sender    string        `json:"sender_fingerprint"`
+	Members   []string      `json:"members"`
+	Created   time.Time     `json:"created"`
+	Key       *messageInfos `json:"key"`
+	Signature []byte        `json:"signature"`
+}
+
+// ChannelKeyCreate wrap a new channel key with the public key of every member and send it
+func (api *Server) ChannelKeyCreate(ctx context.Context, channelName string, members []*user.User, k *channel.Key) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.DefaultTimeout())
+	defer cancel()
+
+	wrapped, err := encryptForMembers(members, k.Secret)
+	if err != nil {
+		return fmt.Errorf("unable to wrap channel key: %w", err)
+	}
+	// members only accept keys signed by one of them, the server can't forge one
+	pKey, err := user.PrivateKey()
+	if err != nil {
+		return err
+	}
+	signature, err := pKey.Sign(channelKeySignedData(channelName, k.ID, k.Members, k.Secret))
+	if err != nil {
+		return fmt.Errorf("unable to sign channel key: %w", err)
+	}
+
+	requestBody, err := json.Marshal(&channelKeyCreateRequest{
+		KeyID:     k.ID,
+		Members:   k.Members,
+		Keys:      wrapped,
+		Signature: signature,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to marshal json: %w", err)
+	}
+
+	_, err = api.Post(ctx, fmt.Sprintf("chan/%s/keys", url.QueryEscape(channelName)), http.StatusCreated, CONTENT_TYPE_JSON, bytes.NewReader(requestBody))
+	if err != nil {
+		return fmt.Errorf("unable to get response: %w", err)
+	}
+	return nil
+}
+
+// ChannelKeyList fetch the keys of a channel and store the ones wrapped for the logged user
+func (api *Server) ChannelKeyList(ctx context.Context, channelName string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.DefaultTimeout())
+	defer cancel()
+
+	response, err := api.Get(ctx, fmt.Sprintf("chan/%s/keys", url.QueryEscape(channelName)), http.StatusOK, nil)
+	if err != nil {
+		return fmt.Errorf("unable to get response: %w", err)
+	}
+	defer response.Body.Close() // nolint: errcheck
+	raw, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read response data: %w", err)
+	}
+
+	list := []*channelKeyResponse{}
+	if err = json.Unmarshal(raw, &list); err != nil {
+		return fmt.Errorf("unable to parse response data: %w", err)
+	}
+
+	pKey, err := user.PrivateKey()
+	if err != nil {
+		return err
+	}
+	for _, ckr := range list {
+		if ckr.Key == nil || ckr.Key.Receiver != user.Logged.PublicKeyDerBase64 {
+			continue
+		}
+		secret, err := pKey.Decrypt(ckr.Key.Message.Message, ckr.Key.Message.Keys, ckr.Key.Message.Integrity)
+		if err != nil {
+			log.Warningf("unable to unwrap key %q of channel %q: %v", ckr.KeyID, channelName, err)
+			continue
+		}
+		if err = verifyChannelKey(channelName, ckr, secret); err != nil {
+			log.Warningf("ignoring key %q of channel %q: %v", ckr.KeyID, channelName, err)
+			continue
+		}
+		channel.AddKey(channelName, &channel.Key{
+			ID:      ckr.KeyID,
+			Secret:  secret,
+			Members: ckr.Members,
+			Created: ckr.Created,
+		})
+	}
+	return nil
+}
+
+// verifyChannelKey check the channel key has been signed by its sender, who
+// must be a member of the channel and one of the members it's distributed to
+func verifyChannelKey(channelName string, ckr *channelKeyResponse, secret []byte) (err error) {
+	pubKey, err := senderPublicKey(channelName, ckr.Sender, ckr.Members)
+	if err != nil {
+		return err
+	}
+	members := append([]string{}, ckr.Members...)
+	sort.Strings(members)
+	if err = pubKey.Verify(channelKeySignedData(channelName, ckr.KeyID, members, secret), ckr.Signature); err != nil {
+		return fmt.Errorf("invalid signature of %q: %w", ckr.Sender, err)
+	}
+	return nil
+}
+
+// senderPublicKey return the public key of the sender of a key distributed
+// to members, the sender must be a member of the channel and one of members
+func senderPublicKey(channelName string, fingerprint string, members []string) (pubKey *identity.PublicKey, err error) {
+	sender, ok := channelMember(channelName, fingerprint)
+	if !ok {
+		return nil, fmt.Errorf("sender %q is not a member of the channel", fingerprint)
+	}
+	distributed := false
+	for _, member := range members {
+		distributed = distributed || member == fingerprint
+	}
+	if !distributed {
+		return nil, fmt.Errorf("sender %q is not a member of the key", fingerprint)
+	}
+
+	if pubKey, err = sender.PublicKey(); err != nil {
+		return nil, fmt.Errorf("unable to parse public key of %q: %w", fingerprint, err)
+	}
+	return pubKey, nil
+}
+
+// channelKeySignedData bind a channel key to its channel, id and members,
+// only a digest of the secret is signed
+func channelKeySignedData(channelName string, keyID string, members []string, secret []byte) []byte {
+	digest := sha256.Sum256(secret)
+	return []byte(fmt.Sprintf("nebulo channel key:%s:%s:%s:%x", channelName, keyID, strings.Join(members, ","), digest))
+}
+
+// channelMember find a member of a channel, or the logged user, by fingerprint
+func channelMember(channelName string, fingerprint string) (member *user.User, ok bool) {
+	if fingerprint == user.Logged.KeyFingerprint {
+		return user.Logged, true
+	}
+	c, ok := channel.Find(channelName)
+	if !ok {
+		return nil, false
+	}
+	for i := range c.Members {
+		if c.Members[i].KeyFingerprint == fingerprint {
+			return &c.Members[i], true
+		}
+	}
+	return nil, false
+}
+
+// channelCurrentKey return the key to use to encrypt a message, the key is
+// rotated when the channel members changed since its distribution
+func (api *Server) channelCurrentKey(ctx context.Context, c *channel.Channel) (k *channel.Key, err error) {
+	members := channelRecipients(c)
+	fingerprints := []string{}
+	for _, member := range members {
+		fingerprints = append(fingerprints, member.KeyFingerprint)
+	}
+
+	if !channel.HasKeys(c.Name) {
+		if err = api.ChannelKeyList(ctx, c.Name); err != nil {
+			log.Warningf("unable to fetch keys of channel %q: %v", c.Name, err)
+		}
+	}
+	if k, ok := channel.CurrentKey(c.Name); ok && k.DistributedTo(fingerprints) {
+		return k, nil
+	}
+
+	log.Infof("members of channel %q changed, rotating channel key", c.Name)
+	if k, err = channel.NewKey(fingerprints); err != nil {
+		return nil, fmt.Errorf("unable to create channel key: %w", err)
+	}
+	if err = api.ChannelKeyCreate(ctx, c.Name, members, k); err != nil {
+		return nil, fmt.Errorf("unable to distribute channel key: %w", err)
+	}
+	channel.AddKey(c.Name, k)
+	return k, nil
+}
+
+// channelRecipients return the members of the channel, including the logged
+// user so he can read his own messages
+func channelRecipients(c *channel.Channel) (members []*user.User) {
+	loggedIsMember := false
+	for i := range c.Members {
+		members = append(members, &c.Members[i])
+		if c.Members[i].KeyFingerprint == user.Logged.KeyFingerprint {
+			loggedIsMember = true
+		}
+	}
+	if !loggedIsMember {
+		members = append(members, user.Logged)
+	}
+	return members
+}
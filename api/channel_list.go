@@ -1,14 +1,14 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 
-	"github.com/krostar/nebulo-golib/log"
-
 	"github.com/krostar/nebulo-client-desktop/channel"
+	"github.com/krostar/nebulo-client-desktop/config"
 )
 
 type channelListRequest struct {
@@ -16,20 +16,21 @@ type channelListRequest struct {
 	Offset int `json:"offset,omitempty"`
 }
 
-func (api *Server) ChannelList() (list map[string]*channel.Channel, err error) {
-	log.Debugln("doing Channel Create call")
+func (api *Server) ChannelList(ctx context.Context) (list map[string]*channel.Channel, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.DefaultTimeout())
+	defer cancel()
 
-	response, err := api.Get("chans", http.StatusOK, nil)
+	response, err := api.Get(ctx, "chans", http.StatusOK, nil)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get response: %v", err)
+		return nil, fmt.Errorf("unable to get response: %w", err)
 	}
 	defer response.Body.Close() // nolint: errcheck
 	raw, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read response data: %v", err)
+		return nil, fmt.Errorf("unable to read response data: %w", err)
 	}
 	if err = json.Unmarshal(raw, &list); err != nil {
-		return nil, fmt.Errorf("unable to parse response data: %v", err)
+		return nil, fmt.Errorf("unable to parse response data: %w", err)
 	}
 
 	return list, nil
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/config"
+)
+
+// the range of server versions this client can talk to, the maximum is excluded
+const (
+	MinServerVersion = "0.1.0"
+	MaxServerVersion = "1.0.0"
+)
+
+// capabilities a server can advertise in its version response
+const (
+	CapabilityAttachments = "attachments"
+	CapabilityPagination  = "pagination"
+)
+
+// capabilitiesSince is the version from which a server have a capability,
+// used when the server doesn't advertise its capabilities
+var capabilitiesSince = map[string]string{
+	CapabilityAttachments: "0.2.0",
+	CapabilityPagination:  "0.1.0",
+}
+
+// ErrIncompatible is returned when the server version is out of the supported range
+var ErrIncompatible = errors.New("incompatible server version")
+
+// semver is a parsed major.minor.patch version
+type semver [3]int
+
+// parseSemver parse versions like "1.2.3" or "v1.2.3-rc1+build", missing
+// minor and patch numbers are zeros
+func parseSemver(version string) (v semver, err error) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) > len(v) {
+		return v, fmt.Errorf("too many parts in version %q", version)
+	}
+	for i, part := range parts {
+		if v[i], err = strconv.Atoi(part); err != nil || v[i] < 0 {
+			return v, fmt.Errorf("invalid version number %q", part)
+		}
+	}
+	return v, nil
+}
+
+// less return whether v is older than o
+func (v semver) less(o semver) bool {
+	for i := range v {
+		if v[i] != o[i] {
+			return v[i] < o[i]
+		}
+	}
+	return false
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// negotiate check the server version is supported and store its capabilities
+func (api *Server) negotiate(version *VersionResponse) (err error) {
+	min, _ := parseSemver(MinServerVersion)
+	max, _ := parseSemver(MaxServerVersion)
+
+	current, err := parseSemver(version.Version)
+	if err != nil && !config.Current().Run.AllowUnversionedServer {
+		return fmt.Errorf("%w: unable to parse server version %q: %v", ErrIncompatible, version.Version, err)
+	} else if err != nil { // development builds don't have a version
+		log.Warningf("unable to parse server version %q: %v, assuming it's compatible and up to date", version.Version, err)
+		current = max
+	} else if current.less(min) {
+		return fmt.Errorf("%w: server version %s is too old, %s or newer is required", ErrIncompatible, current, min)
+	} else if !current.less(max) {
+		return fmt.Errorf("%w: server version %s is too new, a version older than %s is required, please update the client", ErrIncompatible, current, max)
+	}
+
+	api.capabilities = make(map[string]bool)
+	if version.Capabilities != nil {
+		for _, capability := range version.Capabilities {
+			api.capabilities[capability] = true
+		}
+	} else {
+		for capability, since := range capabilitiesSince {
+			v, _ := parseSemver(since)
+			api.capabilities[capability] = !current.less(v)
+		}
+	}
+	log.Infof("server capabilities: %v", api.capabilities)
+	return nil
+}
+
+// Supports return whether the server advertised the capability
+func (api *Server) Supports(capability string) bool {
+	return api.capabilities[capability]
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// sentinel errors matched by *Error through errors.Is
+var (
+	// ErrUnauthorized is matched when the server refused the identity
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is matched when the identity isn't allowed to do the request
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound is matched when the requested resource doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrValidation is matched when the server rejected the request parameters
+	ErrValidation = errors.New("invalid parameters")
+	// ErrConflict is matched when the resource already exists
+	ErrConflict = errors.New("conflict")
+	// ErrServer is matched when the server failed to handle the request
+	ErrServer = errors.New("server error")
+)
+
+// ErrorDetail is one error reported by the server
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+// Error is returned when the server answered with an unexpected status code
+type Error struct {
+	Status   int
+	Expected int
+	Errors   []ErrorDetail
+}
+
+// newError create an Error from a response body, the raw body is used
+// as message when it doesn't contain the errors list sent by the server
+func newError(status int, expected int, raw []byte) *Error {
+	e := &Error{Status: status, Expected: expected}
+	body := struct {
+		Errors []ErrorDetail `json:"errors"`
+	}{}
+	if err := json.Unmarshal(raw, &body); err == nil && len(body.Errors) > 0 {
+		e.Errors = body.Errors
+	} else if message := strings.TrimSpace(string(raw)); message != "" {
+		e.Errors = []ErrorDetail{{Message: message}}
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	details := make([]string, 0, len(e.Errors))
+	for _, d := range e.Errors {
+		detail := d.Message
+		if d.Field != "" {
+			detail = fmt.Sprintf("%s: %s", d.Field, detail)
+		}
+		if d.Code != "" {
+			detail = fmt.Sprintf("%s (%s)", detail, d.Code)
+		}
+		details = append(details, detail)
+	}
+	message := fmt.Sprintf("bad status code: expected %d receive %d", e.Expected, e.Status)
+	if len(details) > 0 {
+		message = fmt.Sprintf("%s; %s", message, strings.Join(details, "; "))
+	}
+	return message
+}
+
+// Is match the sentinel error corresponding to the status code
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.Status == http.StatusForbidden
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrValidation:
+		return e.Status == http.StatusBadRequest || e.Status == http.StatusUnprocessableEntity
+	case ErrConflict:
+		return e.Status == http.StatusConflict
+	case ErrServer:
+		return e.Status >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// HasCode return whether the server reported an error with this code
+func (e *Error) HasCode(code string) bool {
+	for _, d := range e.Errors {
+		if d.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields return the invalid fields reported by the server, with their message
+func (e *Error) Fields() map[string]string {
+	fields := make(map[string]string)
+	for _, d := range e.Errors {
+		if d.Field != "" {
+			fields[d.Field] = d.Message
+		}
+	}
+	return fields
+}
+
+// IsUnauthorized return whether err is due to the server refusing the identity
+func IsUnauthorized(err error) bool {
+	return errors.Is(err, ErrUnauthorized)
+}
+
+// IsForbidden return whether err is due to the logged user not being allowed to do the request
+func IsForbidden(err error) bool {
+	return errors.Is(err, ErrForbidden)
+}
+
+// identityRejected return whether the tls handshake failed because the
+// server refused the certificate of the logged user
+func identityRejected(err error) bool {
+	message := err.Error()
+	for _, alert := range []string{"bad certificate", "certificate revoked", "certificate expired", "certificate required", "unknown certificate authority"} {
+		if strings.Contains(message, "remote error: tls: "+alert) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound return whether err is due to a missing resource
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsValidation return whether err is due to invalid request parameters
+func IsValidation(err error) bool {
+	return errors.Is(err, ErrValidation)
+}
+
+// IsOffline return whether err is due to the server being unreachable
+func IsOffline(err error) bool {
+	return errors.Is(err, ErrOffline)
+}
+
+// AsError return the server error wrapped in err, if any
+func AsError(err error) (e *Error, ok bool) {
+	ok = errors.As(err, &e)
+	return e, ok
+}
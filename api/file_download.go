@@ -0,0 +1,29 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/krostar/nebulo-client-desktop/config"
+)
+
+// FileDownload return the encrypted file stored on the server
+func (api *Server) FileDownload(ctx context.Context, id string) (ciphertext []byte, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.FilesTimeout())
+	defer cancel()
+
+	response, err := api.Get(ctx, fmt.Sprintf("file/%s", url.QueryEscape(id)), http.StatusOK, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get response: %w", err)
+	}
+	defer response.Body.Close() // nolint: errcheck
+	ciphertext, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response data: %w", err)
+	}
+
+	return ciphertext, nil
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/krostar/nebulo-client-desktop/config"
+)
+
+type fileUploadResponse struct {
+	ID string `json:"id"`
+}
+
+// FileUpload store an encrypted file on the server and return its identifier
+func (api *Server) FileUpload(ctx context.Context, ciphertext []byte) (id string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.FilesTimeout())
+	defer cancel()
+
+	response, err := api.Post(ctx, "file", http.StatusCreated, CONTENT_TYPE_OCTET_STREAM, bytes.NewReader(ciphertext))
+	if err != nil {
+		return "", fmt.Errorf("unable to get response: %w", err)
+	}
+	defer response.Body.Close() // nolint: errcheck
+	raw, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to read response data: %w", err)
+	}
+
+	fur := &fileUploadResponse{}
+	if err = json.Unmarshal(raw, fur); err != nil {
+		return "", fmt.Errorf("unable to parse response data: %w", err)
+	}
+
+	return fur.ID, nil
+}
@@ -0,0 +1,379 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/config"
+)
+
+// placeholders replacing the scrubbed values of the compared requests
+const (
+	scrubbedPEM    = "scrubbed:pem"
+	scrubbedBlob   = "scrubbed:blob"
+	scrubbedBinary = "scrubbed:binary"
+)
+
+var (
+	// ErrNoFixture is returned when no recorded fixture match a replayed request
+	ErrNoFixture = errors.New("no fixture match the request")
+	// ErrFixtureMismatch is returned when a replayed request body differ from the recorded one
+	ErrFixtureMismatch = errors.New("request doesn't match the fixture")
+
+	// blobRegexp match the base64 and hex encoded values long enough to be keys,
+	// signatures, ciphertexts or fingerprints
+	blobRegexp = regexp.MustCompile(`^[A-Za-z0-9+/=_-]{32,}$`)
+	slugRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// useFixtures add the recorder or the replayer to the end of the middlewares
+// chain, to see the requests as they are sent
+func (api *Server) useFixtures(options *config.FixturesOptions) (err error) {
+	switch {
+	case options.Record != "" && options.Replay != "":
+		return errors.New("fixtures can't be recorded and replayed at the same time")
+	case options.Record != "":
+		recorder, err := NewRecorder(options.Record)
+		if err != nil {
+			return err
+		}
+		log.Warningf("recording requests and responses in %q", options.Record)
+		api.Use(recorder.Middleware)
+	case options.Replay != "":
+		replayer, err := NewReplayer(options.Replay)
+		if err != nil {
+			return err
+		}
+		log.Warningf("replaying the responses recorded in %q, the server won't be contacted", options.Replay)
+		api.Use(replayer.Middleware)
+	}
+	return nil
+}
+
+// Fixture is a recorded request and its response, with their bodies as
+// exchanged: json bodies are kept as is, text bodies as json strings and
+// binary bodies as base64 json strings; the certificates, keys and
+// ciphertexts of the requests are only scrubbed to compare them, as they
+// change on every run, so the replayed responses can be parsed
+type Fixture struct {
+	Method   string `json:"method"`
+	Endpoint string `json:"endpoint"` // path and query
+	Request  struct {
+		ContentType string          `json:"content_type,omitempty"`
+		Body        json.RawMessage `json:"body,omitempty"`
+	} `json:"request"`
+	Response struct {
+		Status      int             `json:"status"`
+		ContentType string          `json:"content_type,omitempty"`
+		Body        json.RawMessage `json:"body,omitempty"`
+	} `json:"response"`
+
+	used bool // already served by the replayer
+}
+
+// Recorder write each request made to the server and its response in a
+// directory, to be replayed by a Replayer; the fixtures contain the
+// certificates, public keys and ciphertexts exchanged, but never a private
+// key, record them with test identities before commiting them
+type Recorder struct {
+	mutex sync.Mutex
+	dir   string
+	count int
+}
+
+// NewRecorder create a recorder writing in dir, which must be empty
+// to not mix fixtures of different sessions
+func NewRecorder(dir string) (r *Recorder, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create fixtures directory %q: %v", dir, err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read fixtures directory %q: %v", dir, err)
+	}
+	if len(files) > 0 {
+		return nil, fmt.Errorf("fixtures directory %q is not empty", dir)
+	}
+	return &Recorder{dir: dir}, nil
+}
+
+// Middleware record each request and its response; the request is sent
+// even if it can't be recorded
+func (r *Recorder) Middleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		requestBody, err := readRequestBody(request)
+		if err != nil {
+			return nil, err
+		}
+		response, err := next.RoundTrip(request)
+		if err != nil {
+			return nil, err
+		}
+		responseBody, err := ioutil.ReadAll(response.Body)
+		response.Body.Close() // nolint: errcheck
+		if err != nil {
+			return nil, fmt.Errorf("unable to read response data: %w", err)
+		}
+		response.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+		fixture := &Fixture{Method: request.Method, Endpoint: request.URL.RequestURI()}
+		fixture.Request.ContentType = request.Header.Get("Content-Type")
+		fixture.Request.Body = encodeBody(fixture.Request.ContentType, requestBody)
+		fixture.Response.Status = response.StatusCode
+		fixture.Response.ContentType = response.Header.Get("Content-Type")
+		fixture.Response.Body = encodeBody(fixture.Response.ContentType, responseBody)
+		if err = r.write(fixture); err != nil {
+			log.Warningf("unable to record fixture: %v", err)
+		}
+		return response, nil
+	})
+}
+
+// write save a fixture in a file named after its order, method and path
+func (r *Recorder) write(fixture *Fixture) (err error) {
+	raw, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode fixture: %v", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.count++
+	path := strings.SplitN(fixture.Endpoint, "?", 2)[0]
+	slug := strings.Trim(slugRegexp.ReplaceAllString(strings.ToLower(path), "-"), "-")
+	filename := filepath.Join(r.dir, fmt.Sprintf("%03d-%s-%s.json", r.count, strings.ToLower(fixture.Method), slug))
+	if err = ioutil.WriteFile(filename, append(raw, '\n'), 0600); err != nil {
+		return fmt.Errorf("unable to write fixture %q: %v", filename, err)
+	}
+	return nil
+}
+
+// Replayer serve recorded responses instead of contacting the server
+type Replayer struct {
+	mutex    sync.Mutex
+	fixtures []*Fixture
+	names    []string
+}
+
+// NewReplayer load the fixtures recorded in dir, in the order they have been recorded
+func NewReplayer(dir string) (r *Replayer, err error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list fixtures in %q: %v", dir, err)
+	}
+	sort.Strings(names)
+
+	r = &Replayer{names: names}
+	for _, name := range names {
+		raw, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read fixture %q: %v", name, err)
+		}
+		fixture := &Fixture{}
+		if err = json.Unmarshal(raw, fixture); err != nil {
+			return nil, fmt.Errorf("unable to parse fixture %q: %v", name, err)
+		}
+		r.fixtures = append(r.fixtures, fixture)
+	}
+	if len(r.fixtures) == 0 {
+		return nil, fmt.Errorf("no fixture found in %q", dir)
+	}
+	return r, nil
+}
+
+// Middleware answer with the first unused fixture matching the method and
+// endpoint of the request, the request body must match the recorded one
+// once both are scrubbed
+func (r *Replayer) Middleware(_ http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		requestBody, err := readRequestBody(request)
+		if err != nil {
+			return nil, err
+		}
+		contentType := request.Header.Get("Content-Type")
+		body := scrubBody(contentType, requestBody)
+		endpoint := request.URL.RequestURI()
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		for i, fixture := range r.fixtures {
+			if fixture.used || fixture.Method != request.Method || fixture.Endpoint != endpoint {
+				continue
+			}
+			fixture.used = true
+			recorded, err := decodeBody(fixture.Request.ContentType, fixture.Request.Body)
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode request of fixture %s: %v", r.names[i], err)
+			}
+			if recorded := scrubBody(fixture.Request.ContentType, recorded); !sameJSON(recorded, body) {
+				return nil, fmt.Errorf("%w %s: sent %s, recorded %s", ErrFixtureMismatch, r.names[i], body, recorded)
+			}
+			response, err := fixture.response(request)
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode response of fixture %s: %v", r.names[i], err)
+			}
+			return response, nil
+		}
+		return nil, fmt.Errorf("%w: %s %s", ErrNoFixture, request.Method, endpoint)
+	})
+}
+
+// response create the recorded response
+func (f *Fixture) response(request *http.Request) (*http.Response, error) {
+	body, err := decodeBody(f.Response.ContentType, f.Response.Body)
+	if err != nil {
+		return nil, err
+	}
+	header := make(http.Header)
+	if f.Response.ContentType != "" {
+		header.Set("Content-Type", f.Response.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Response.Status, http.StatusText(f.Response.Status)),
+		StatusCode:    f.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}, nil
+}
+
+// encodeBody return the body as it's recorded in a fixture, see Fixture
+func encodeBody(contentType string, body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	var value interface{} = base64.StdEncoding.EncodeToString(body)
+	switch kind := bodyKind(contentType); {
+	case kind == CONTENT_TYPE_JSON:
+		var indented bytes.Buffer
+		if json.Indent(&indented, body, "", "  ") == nil {
+			return indented.Bytes()
+		}
+		value = string(body) // invalid json is still worth recording
+	case kind == "text":
+		value = string(body)
+	}
+	raw, _ := json.Marshal(value) // nolint: errcheck
+	return raw
+}
+
+// decodeBody return the body recorded in a fixture by encodeBody
+func decodeBody(contentType string, recorded json.RawMessage) (body []byte, err error) {
+	if len(recorded) == 0 {
+		return nil, nil
+	}
+	var text string // json strings are text, binary or invalid json bodies
+	if err = json.Unmarshal(recorded, &text); err != nil {
+		return recorded, nil
+	}
+	if bodyKind(contentType) == CONTENT_TYPE_OCTET_STREAM {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+// bodyKind return how a body of this content type is recorded: as json,
+// as text or as binary
+func bodyKind(contentType string) string {
+	switch mediaType, _, _ := mime.ParseMediaType(contentType); {
+	case mediaType == CONTENT_TYPE_JSON:
+		return CONTENT_TYPE_JSON
+	case mediaType == CONTENT_TYPE_PEM, strings.HasPrefix(mediaType, "text/"):
+		return "text"
+	}
+	return CONTENT_TYPE_OCTET_STREAM
+}
+
+// sameJSON return whether two json documents are equal, whatever their indentation
+func sameJSON(a, b json.RawMessage) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// readRequestBody read the request body and replace it, to be sent anyway
+func readRequestBody(request *http.Request) (body []byte, err error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err = ioutil.ReadAll(request.Body)
+	request.Body.Close() // nolint: errcheck
+	if err != nil {
+		return nil, fmt.Errorf("unable to read request data: %w", err)
+	}
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// scrubBody return the body as canonical json with its certificates, keys and
+// ciphertexts replaced by placeholders; other bodies are replaced entirely
+func scrubBody(contentType string, body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	scrubbed := scrubbedBinary
+	switch {
+	case mediaType == CONTENT_TYPE_JSON:
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err == nil {
+			if raw, err := json.MarshalIndent(scrubValue(value), "", "  "); err == nil {
+				return raw
+			}
+		}
+	case strings.HasPrefix(mediaType, "text/"):
+		scrubbed = scrubString(string(body))
+	case mediaType == CONTENT_TYPE_PEM:
+		scrubbed = scrubbedPEM
+	}
+	raw, _ := json.Marshal(scrubbed) // nolint: errcheck
+	return raw
+}
+
+func scrubValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = scrubValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = scrubValue(item)
+		}
+	case string:
+		return scrubString(v)
+	}
+	return value
+}
+
+func scrubString(value string) string {
+	switch {
+	case strings.Contains(value, "-----BEGIN"):
+		return scrubbedPEM
+	case blobRegexp.MatchString(value):
+		return scrubbedBlob
+	}
+	return value
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/krostar/nebulo-client-desktop/message"
+	"github.com/krostar/nebulo-client-desktop/symmetric"
+)
+
+// replayServer create a server answered by the fixtures of dir
+func replayServer(t *testing.T, dir string) (api *Server, replayer *Replayer) {
+	t.Helper()
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("unable to load fixtures: %v", err)
+	}
+	api = &Server{
+		Client:       "nebulo-desktop/test",
+		BaseURL:      "https://nebulo.test",
+		capabilities: map[string]bool{CapabilityPagination: true},
+	}
+	api.Use(replayer.Middleware)
+	api.HTTP = &http.Client{Transport: api.chain(nil)}
+	return api, replayer
+}
+
+// testMessagePayload encrypt a message like MessageCreatePayload, with a
+// new key each time so the ciphertext never match the recorded one
+func testMessagePayload(t *testing.T, text string) []byte {
+	t.Helper()
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	ciphertext, err := symmetric.Seal(secret, []byte(text), messageAdditionalData("general", "k1"))
+	if err != nil {
+		t.Fatalf("unable to encrypt message: %v", err)
+	}
+	payload, err := json.Marshal(&messageCreateRequest{
+		ChannelName: "general",
+		Version:     message.VersionChannelKey,
+		KeyID:       "k1",
+		Message:     &message.SecureMsg{Message: ciphertext},
+	})
+	if err != nil {
+		t.Fatalf("unable to marshal json: %v", err)
+	}
+	return payload
+}
+
+// TestFixturesReplay replay the golden fixtures, which pin the wire format
+// of the channel and message endpoints
+func TestFixturesReplay(t *testing.T) {
+	api, replayer := replayServer(t, "testdata/fixtures")
+	ctx := context.Background()
+	member := "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEsJW2hlIdHWwz6RPlOa9kZni4Hu5eo+f4ne/Yc8cQcVnzWM26zM6n0kqzv1V8Qi3DV2IBVZEKz+6TU9Nb5m8enw=="
+
+	c, err := api.ChannelCreate(ctx, "general", []string{member})
+	if err != nil {
+		t.Fatalf("unable to create channel: %v", err)
+	}
+	if c.Name != "general" || len(c.Members) != 2 {
+		t.Fatalf("unexpected channel %q with %d members", c.Name, len(c.Members))
+	}
+	for _, m := range c.Members {
+		if _, err = m.PublicKey(); err != nil {
+			t.Errorf("unable to parse public key of %q: %v", m.KeyFingerprint, err)
+		}
+	}
+
+	list, err := api.ChannelList(ctx)
+	if err != nil {
+		t.Fatalf("unable to list channels: %v", err)
+	}
+	if _, ok := list["general"]; !ok || len(list) != 1 {
+		t.Fatalf("unexpected channels %v", list)
+	}
+
+	if err = api.MessageCreateFromPayload(ctx, "general", testMessagePayload(t, "hello")); err != nil {
+		t.Fatalf("unable to create message: %v", err)
+	}
+
+	messages, err := api.MessageList(ctx, "general", time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unable to list messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Version != message.VersionChannelKey || len(messages[0].Ciphertext) == 0 {
+		t.Fatalf("unexpected messages %v", messages)
+	}
+	if _, err = messages[0].Sender.PublicKey(); err != nil {
+		t.Errorf("unable to parse public key of the sender: %v", err)
+	}
+
+	for i, fixture := range replayer.fixtures {
+		if !fixture.used {
+			t.Errorf("fixture %s not replayed", replayer.names[i])
+		}
+	}
+}
+
+// TestFixturesReplayMismatch check a request whose format changed is refused
+func TestFixturesReplayMismatch(t *testing.T) {
+	api, _ := replayServer(t, "testdata/fixtures")
+
+	_, err := api.ChannelCreate(context.Background(), "general", nil)
+	if !errors.Is(err, ErrFixtureMismatch) {
+		t.Fatalf("expected a fixture mismatch, got %v", err)
+	}
+}
@@ -1,54 +1,61 @@
 package api
 
 import (
-	"errors"
+	"context"
 	"fmt"
 
-	"github.com/krostar/nebulo-golib/log"
-	"github.com/krostar/nebulo-golib/tools/cert"
-
 	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/identity"
 	"github.com/krostar/nebulo-client-desktop/user"
 )
 
 // Login log a user based on his nebulo signed certificate
-func (api *Server) Login() (loggedUser *user.User, err error) {
-	log.Debugln("doing Login call")
-
+func (api *Server) Login(ctx context.Context) (loggedUser *user.User, err error) {
 	// there is no login call, just check if the current configuration allow a required-auth call
-	loggedUser, err = api.UserProfile()
-	if err != nil { // delete current configuration
-		config.Config.Run.TLS.Key = ""
-		config.Config.Run.TLS.KeyPassword = ""
-		err = fmt.Errorf("unable to login: %v", err)
-	}
-	if errSave := config.SaveFile(); errSave != nil {
-		if err == nil {
-			err = errors.New("")
+	loggedUser, err = api.UserProfile(ctx)
+	if IsUnauthorized(err) { // the identity is refused, delete it from the configuration
+		config.Update(user.ForgetKey)
+		if errSave := config.SaveFile(saveIdentity); errSave != nil {
+			return nil, fmt.Errorf("unable to login: %w and unable to save configuration file: %v", err, errSave)
 		}
-		err = fmt.Errorf("%s and unable to save configuration file: %v", err, errSave)
-	}
-
-	if err != nil {
+		return nil, fmt.Errorf("unable to login: %w", err)
+	} else if err != nil { // the server may be unreachable, the identity is kept
 		return nil, err
 	}
 
+	if err = config.SaveFile(saveIdentity); err != nil {
+		return nil, fmt.Errorf("unable to save configuration file: %v", err)
+	}
 	return user.Login(loggedUser)
 }
 
 // LoginWithCertsFilename do the Login call but with the cert and key path
-func (api *Server) LoginWithCertsFilename(certFilepath string, keyFilePath string, keyPassword []byte) (_ *user.User, err error) {
-	_, _, err = cert.KeyPairFromFiles(certFilepath, keyFilePath, keyPassword)
-	if err != nil {
-		return nil, fmt.Errorf("unable to get certificate from file: %v", err)
+func (api *Server) LoginWithCertsFilename(ctx context.Context, certFilepath string, keyFilePath string, keyPassword []byte) (_ *user.User, err error) {
+	return api.LoginWithIdentity(ctx, identity.Source{
+		Store:    identity.StorePEM,
+		Cert:     certFilepath,
+		Key:      keyFilePath,
+		Password: string(keyPassword),
+	})
+}
+
+// saveIdentity record the identity of the running configuration in the file
+func saveIdentity(file *config.Options) {
+	file.Run.TLS.CopyIdentity(&config.Current().Run.TLS)
+}
+
+// LoginWithIdentity do the Login call with an identity from any store
+func (api *Server) LoginWithIdentity(ctx context.Context, source identity.Source) (_ *user.User, err error) {
+	if _, err = identityCertificate(source); err != nil {
+		return nil, fmt.Errorf("unable to get certificate from identity: %w", err)
 	}
 
-	config.Config.Run.TLS.Cert = certFilepath
-	config.Config.Run.TLS.Key = keyFilePath
-	config.Config.Run.TLS.KeyPassword = string(keyPassword)
-	if err = changeTLSOptions(API, &config.Config.Run.TLS); err != nil {
-		return nil, fmt.Errorf("unable to change tls options to login: %v", err)
+	config.Update(func(c *config.Options) {
+		user.SetIdentitySource(&c.Run.TLS, source)
+	})
+	if err = changeTLSOptions(API, &config.Current().Run.TLS); err != nil {
+		return nil, fmt.Errorf("unable to change tls options to login: %w", err)
 	}
 
-	return api.Login()
+	return api.Login(ctx)
 }
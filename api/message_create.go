@@ -2,20 +2,23 @@ package api
 
 import (
 	"bytes"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/base64"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"runtime"
+	"sync"
 
 	"github.com/krostar/nebulo-golib/log"
-	"github.com/krostar/nebulo-golib/tools/crypto"
 
 	"github.com/krostar/nebulo-client-desktop/channel"
+	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/identity"
 	"github.com/krostar/nebulo-client-desktop/message"
+	"github.com/krostar/nebulo-client-desktop/ratchet"
+	"github.com/krostar/nebulo-client-desktop/symmetric"
+	"github.com/krostar/nebulo-client-desktop/user"
 )
 
 type messageInfos struct {
@@ -24,57 +27,162 @@ type messageInfos struct {
 }
 
 type messageCreateRequest struct {
-	ChannelName string         `json:"channel_name"`
-	Messages    []messageInfos `json:"messages"`
+	ChannelName string             `json:"channel_name"`
+	Version     int                `json:"version"`
+	KeyID       string             `json:"key_id,omitempty"`   // VersionChannelKey
+	ChainID     string             `json:"chain_id,omitempty"` // VersionRatchet
+	Index       uint32             `json:"index,omitempty"`    // VersionRatchet
+	Message     *message.SecureMsg `json:"message,omitempty"`  // VersionChannelKey and VersionRatchet
+	Messages    []messageInfos     `json:"messages,omitempty"` // VersionPerMember
 }
 
-func (api *Server) MessageCreate(channelName string, plaintext string) (err error) {
-	log.Debugln("doing Message Create call")
-
-	ciphertexts := []messageInfos{}
+// MessageCreate encrypt a message with the channel key and send it
+func (api *Server) MessageCreate(ctx context.Context, channelName string, plaintext string) (err error) {
+	payload, err := api.MessageCreatePayload(ctx, channelName, plaintext)
+	if err != nil {
+		return err
+	}
+	return api.MessageCreateFromPayload(ctx, channelName, payload)
+}
 
-	for _, member := range channel.Channels[channelName].Members {
-		log.Debugf("sign %q with %q", plaintext, member.KeyFingerprint)
-		pkeyDER, err := base64.StdEncoding.DecodeString(member.PublicKeyDerBase64)
-		if err != nil {
-			return fmt.Errorf("failed to decode b64 pkey: %v", err)
-		}
-		pkey, err := x509.ParsePKIXPublicKey(pkeyDER)
-		if err != nil {
-			return fmt.Errorf("failed to parse DER encoded public key: %v", err)
-		}
-		rsaPKey, ok := pkey.(*rsa.PublicKey)
-		if !ok {
-			return errors.New("cant cast private key to rsa private key")
-		}
+// MessageCreatePayload encrypt a message with the sending chain when forward secrecy
+// is active or with the channel key otherwise, and return the request body to
+// send later with MessageCreateFromPayload
+func (api *Server) MessageCreatePayload(ctx context.Context, channelName string, plaintext string) (payload []byte, err error) {
+	c, ok := channel.Find(channelName)
+	if !ok {
+		return nil, fmt.Errorf("unknown channel %q", channelName)
+	}
+	if active, _ := ForwardSecrecy(c); active {
+		return api.messageCreateRatchetPayload(ctx, c, plaintext)
+	}
 
-		ciphertext, keys, hmac, err := crypto.Crypt([]byte(plaintext), *rsaPKey)
-		if err != nil {
-			return fmt.Errorf("unable to encode message with recipient pkey: %v", err)
-		}
-		log.Debugln(ciphertext, keys, hmac)
-		secureMsg := message.SecureMsg{
-			Message:   ciphertext,
-			Keys:      keys,
-			Integrity: hmac,
-		}
-		ciphertexts = append(ciphertexts, messageInfos{
-			Receiver: member.PublicKeyDerBase64,
-			Message:  secureMsg,
-		})
+	k, err := api.channelCurrentKey(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := symmetric.Seal(k.Secret, []byte(plaintext), messageAdditionalData(channelName, k.ID))
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt message with channel key: %w", err)
 	}
 
-	requestBody, err := json.Marshal(&messageCreateRequest{
+	payload, err = json.Marshal(&messageCreateRequest{
 		ChannelName: channelName,
-		Messages:    ciphertexts,
+		Version:     message.VersionChannelKey,
+		KeyID:       k.ID,
+		Message:     &message.SecureMsg{Message: ciphertext},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal json: %w", err)
+	}
+	return payload, nil
+}
+
+func (api *Server) messageCreateRatchetPayload(ctx context.Context, c *channel.Channel, plaintext string) (payload []byte, err error) {
+	if err = api.channelSendingChain(ctx, c); err != nil {
+		return nil, err
+	}
+	chainID, index, ciphertext, err := ratchet.Encrypt(c.Name, []byte(plaintext), func(chainID string, index uint32) []byte {
+		return ratchetAdditionalData(c.Name, chainID, index)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt message with sending chain: %w", err)
+	}
+
+	payload, err = json.Marshal(&messageCreateRequest{
+		ChannelName: c.Name,
+		Version:     message.VersionRatchet,
+		ChainID:     chainID,
+		Index:       index,
+		Message:     &message.SecureMsg{Message: ciphertext},
 	})
 	if err != nil {
-		return fmt.Errorf("unable to marshal json: %v", err)
+		return nil, fmt.Errorf("unable to marshal json: %w", err)
 	}
+	return payload, nil
+}
 
-	_, err = api.Post(fmt.Sprintf("chan/%s/message", url.QueryEscape(channelName)), http.StatusCreated, CONTENT_TYPE_JSON, bytes.NewReader(requestBody))
+// MessageCreateFromPayload send a message encrypted by MessageCreatePayload
+func (api *Server) MessageCreateFromPayload(ctx context.Context, channelName string, payload []byte) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.MessagesTimeout())
+	defer cancel()
+
+	_, err = api.Post(ctx, fmt.Sprintf("chan/%s/message", url.QueryEscape(channelName)), http.StatusCreated, CONTENT_TYPE_JSON, bytes.NewReader(payload))
 	if err != nil {
-		return fmt.Errorf("unable to get response: %v", err)
+		return fmt.Errorf("unable to get response: %w", err)
 	}
 	return nil
 }
+
+// messageAdditionalData bind a message ciphertext to its channel and key
+func messageAdditionalData(channelName string, keyID string) []byte {
+	return []byte(fmt.Sprintf("%s:%s", channelName, keyID))
+}
+
+// ratchetAdditionalData bind a message ciphertext to its channel and position in the chain
+func ratchetAdditionalData(channelName string, chainID string, index uint32) []byte {
+	return []byte(fmt.Sprintf("%s:%s:%d", channelName, chainID, index))
+}
+
+// encryptWorkers is the maximum number of members encrypted for concurrently
+var encryptWorkers = runtime.NumCPU()
+
+// encryptForMembers encrypt plaintext for every member with its public key,
+// members are handled concurrently by a bounded pool of workers
+func encryptForMembers(members []*user.User, plaintext []byte) (ciphertexts []messageInfos, err error) {
+	ciphertexts = make([]messageInfos, len(members))
+	errs := make([]error, len(members))
+
+	var (
+		wg   sync.WaitGroup
+		jobs = make(chan int)
+	)
+	for w := 0; w < encryptWorkers && w < len(members); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				ciphertexts[i], errs[i] = encryptForMember(members[i], plaintext)
+			}
+		}()
+	}
+	for i := range members {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err = range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ciphertexts, nil
+}
+
+// encryptForMember encrypt plaintext for a member with its public key
+func encryptForMember(member *user.User, plaintext []byte) (ciphertext messageInfos, err error) {
+	log.Debugf("encrypt for %q", member.KeyFingerprint)
+	pkey, err := member.PublicKey()
+	if err != nil {
+		return ciphertext, fmt.Errorf("unable to parse public key of %q: %v", member.KeyFingerprint, err)
+	}
+
+	encrypted, keys, integrity, err := pkey.Encrypt(plaintext)
+	if err != nil {
+		return ciphertext, fmt.Errorf("unable to encode message with recipient pkey: %w", err)
+	}
+	secureMsg := message.SecureMsg{
+		Message:   encrypted,
+		Keys:      keys,
+		Integrity: integrity,
+	}
+	// rsa is implied when no algorithm is set, as expected by older clients
+	if pkey.Algorithm() != identity.AlgorithmRSA {
+		secureMsg.Algorithm = string(pkey.Algorithm())
+	}
+	return messageInfos{
+		Receiver: member.PublicKeyDerBase64,
+		Message:  secureMsg,
+	}, nil
+}
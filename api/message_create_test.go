@@ -0,0 +1,53 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/krostar/nebulo-client-desktop/user"
+)
+
+// benchmarkMembers create members with rsa keys, the key type of most identities
+func benchmarkMembers(b *testing.B, count int) (members []*user.User) {
+	b.Helper()
+	for i := 0; i < count; i++ {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			b.Fatalf("unable to generate key: %v", err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			b.Fatalf("unable to marshal public key: %v", err)
+		}
+		members = append(members, &user.User{
+			KeyFingerprint:     fmt.Sprintf("member-%d", i),
+			PublicKeyDerBase64: base64.StdEncoding.EncodeToString(der),
+		})
+	}
+	return members
+}
+
+// BenchmarkMessageCreate measure the throughput of the encryption for every
+// member of a channel, done when a message is sent with a new channel key
+func BenchmarkMessageCreate(b *testing.B) {
+	plaintext := make([]byte, 32) // a channel key
+	if _, err := rand.Read(plaintext); err != nil {
+		b.Fatalf("unable to generate plaintext: %v", err)
+	}
+
+	for _, count := range []int{1, 10, 100} {
+		members := benchmarkMembers(b, count)
+		b.Run(fmt.Sprintf("members=%d", count), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := encryptForMembers(members, plaintext); err != nil {
+					b.Fatalf("unable to encrypt for members: %v", err)
+				}
+			}
+			b.ReportMetric(float64(count*b.N)/b.Elapsed().Seconds(), "members/s")
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/channel"
+	"github.com/krostar/nebulo-client-desktop/identity"
+	"github.com/krostar/nebulo-client-desktop/message"
+	"github.com/krostar/nebulo-client-desktop/ratchet"
+	"github.com/krostar/nebulo-client-desktop/symmetric"
+	"github.com/krostar/nebulo-client-desktop/user"
+)
+
+// encryptedMessage is what is needed to decrypt a message, whatever its version
+type encryptedMessage struct {
+	ChannelName string
+	Sender      string // fingerprint of the sender
+	Version     int
+	KeyID       string
+	ChainID     string
+	Index       uint32
+	Secure      *message.SecureMsg
+}
+
+// MessagesDecrypt fill the plaintext of the messages of a channel,
+// whatever the version of the scheme used to encrypt them
+func (api *Server) MessagesDecrypt(ctx context.Context, channelName string, messages []*message.Message) (err error) {
+	pKey, err := user.PrivateKey()
+	if err != nil {
+		return err
+	}
+
+	// fetch the channel keys and chains once if some are missing
+	keysFetched, chainsFetched := false, false
+	for _, m := range messages {
+		if _, ok := channel.FindKey(channelName, m.KeyID); m.Version == message.VersionChannelKey && !ok && !keysFetched {
+			if err = api.ChannelKeyList(ctx, channelName); err != nil {
+				log.Warningf("unable to fetch keys of channel %q: %v", channelName, err)
+			}
+			keysFetched = true
+		}
+		if m.Version == message.VersionRatchet && !ratchet.HasReceivingChain(m.ChainID) && !chainsFetched {
+			if err = api.ChannelChainList(ctx, channelName); err != nil {
+				log.Warningf("unable to fetch chains of channel %q: %v", channelName, err)
+			}
+			chainsFetched = true
+		}
+	}
+
+	for _, m := range messages {
+		plaintext, err := messageDecrypt(&encryptedMessage{
+			ChannelName: channelName,
+			Sender:      m.Sender.KeyFingerprint,
+			Version:     m.Version,
+			KeyID:       m.KeyID,
+			ChainID:     m.ChainID,
+			Index:       m.Index,
+			Secure:      &message.SecureMsg{Message: m.Ciphertext, Keys: m.Keys, Integrity: m.Integrity},
+		}, pKey)
+		if err == ratchet.ErrConsumed {
+			m.Plaintext = "(this message can't be decrypted anymore, its key has been destroyed for forward secrecy " +
+				"and decrypted messages are only kept until the client is closed)"
+			continue
+		} else if err != nil {
+			log.Warningf("unable to decrypt message of %q posted at %s: %v", m.Sender.KeyFingerprint, m.Posted, err)
+			m.Plaintext = "(unable to decrypt this message)"
+			continue
+		}
+		m.Plaintext = string(plaintext)
+	}
+	return nil
+}
+
+// PayloadPlaintext decrypt the copy of the logged user
+// of a message created by MessageCreatePayload
+func PayloadPlaintext(payload []byte) (plaintext string, err error) {
+	mcr := &messageCreateRequest{}
+	if err = json.Unmarshal(payload, mcr); err != nil {
+		return "", fmt.Errorf("unable to parse payload: %w", err)
+	}
+
+	secureMsg := mcr.Message
+	for i := range mcr.Messages {
+		if mcr.Messages[i].Receiver == user.Logged.PublicKeyDerBase64 {
+			secureMsg = &mcr.Messages[i].Message
+		}
+	}
+	if secureMsg == nil {
+		return "", errors.New("payload has not been encrypted for the logged user")
+	}
+
+	pKey, err := user.PrivateKey()
+	if err != nil {
+		return "", err
+	}
+	raw, err := messageDecrypt(&encryptedMessage{
+		ChannelName: mcr.ChannelName,
+		Sender:      user.Logged.KeyFingerprint,
+		Version:     mcr.Version,
+		KeyID:       mcr.KeyID,
+		ChainID:     mcr.ChainID,
+		Index:       mcr.Index,
+		Secure:      secureMsg,
+	}, pKey)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+func messageDecrypt(m *encryptedMessage, pKey *identity.PrivateKey) (plaintext []byte, err error) {
+	switch m.Version {
+	case 0, message.VersionPerMember:
+		if algorithm := m.Secure.Algorithm; algorithm != "" && identity.Algorithm(algorithm) != pKey.Algorithm() {
+			return nil, fmt.Errorf("message encrypted for a %s key, not %s", algorithm, pKey.Algorithm())
+		}
+		return pKey.Decrypt(m.Secure.Message, m.Secure.Keys, m.Secure.Integrity)
+	case message.VersionChannelKey:
+		k, ok := channel.FindKey(m.ChannelName, m.KeyID)
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", m.KeyID)
+		}
+		return symmetric.Open(k.Secret, m.Secure.Message, messageAdditionalData(m.ChannelName, m.KeyID))
+	case message.VersionRatchet:
+		return ratchet.Decrypt(m.ChainID, m.Sender, m.Index, m.Secure.Message, ratchetAdditionalData(m.ChannelName, m.ChainID, m.Index))
+	default:
+		return nil, fmt.Errorf("unsupported message version %d", m.Version)
+	}
+}
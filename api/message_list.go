@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,8 +10,8 @@ import (
 	"time"
 
 	"github.com/google/go-querystring/query"
-	"github.com/krostar/nebulo-golib/log"
 
+	"github.com/krostar/nebulo-client-desktop/config"
 	"github.com/krostar/nebulo-client-desktop/message"
 )
 
@@ -19,31 +20,35 @@ type messageListRequest struct {
 	Limit    int       `url:"limit"`
 }
 
-func (api *Server) MessageList(channelName string, lastRead time.Time) (list []*message.Message, err error) {
-	log.Debugln("doing Message List call")
+func (api *Server) MessageList(ctx context.Context, channelName string, lastRead time.Time) (list []*message.Message, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.MessagesTimeout())
+	defer cancel()
 
 	mlr := &messageListRequest{
 		LastRead: lastRead,
 		Limit:    -50,
 	}
-	queryParams, err := query.Values(mlr)
-	if err != nil {
-		return nil, fmt.Errorf("unable to format query params: %v", err)
+	var queryParams url.Values
+	// servers without pagination always return the whole channel
+	if api.Supports(CapabilityPagination) {
+		if queryParams, err = query.Values(mlr); err != nil {
+			return nil, fmt.Errorf("unable to format query params: %w", err)
+		}
 	}
 
-	response, err := api.Get(fmt.Sprintf("chan/%s/messages", url.QueryEscape(channelName)), http.StatusOK, queryParams)
+	response, err := api.Get(ctx, fmt.Sprintf("chan/%s/messages", url.QueryEscape(channelName)), http.StatusOK, queryParams)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get response: %v", err)
+		return nil, fmt.Errorf("unable to get response: %w", err)
 	}
 	defer response.Body.Close() // nolint: errcheck
 	raw, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read response data: %v", err)
+		return nil, fmt.Errorf("unable to read response data: %w", err)
 	}
 
 	list = []*message.Message{}
 	if err = json.Unmarshal(raw, &list); err != nil {
-		return nil, fmt.Errorf("unable to parse response data: %v", err)
+		return nil, fmt.Errorf("unable to parse response data: %w", err)
 	}
 
 	return list, nil
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/krostar/nebulo-golib/log"
+)
+
+// Middleware wrap the round tripper used to contact the server, to observe
+// or change the requests and their responses
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to use a function as a round tripper
+type RoundTripperFunc func(request *http.Request) (*http.Response, error)
+
+// RoundTrip call f(request)
+func (f RoundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// Use add middlewares to the chain, the first one added is the first to
+// see the requests; it applies to the transport already built, if any
+func (api *Server) Use(middlewares ...Middleware) {
+	api.transportMutex.Lock()
+	defer api.transportMutex.Unlock()
+	api.middlewares = append(api.middlewares, middlewares...)
+	if api.transport != nil {
+		api.mutex.Lock()
+		api.HTTP = &http.Client{Transport: api.chain(api.transport)}
+		api.mutex.Unlock()
+	}
+}
+
+// chain wrap the transport with the middlewares
+func (api *Server) chain(transport http.RoundTripper) (rt http.RoundTripper) {
+	rt = transport
+	for i := len(api.middlewares) - 1; i >= 0; i-- {
+		rt = api.middlewares[i](rt)
+	}
+	return rt
+}
+
+// LoggingMiddleware log the method, endpoint, status and latency of each
+// request at the request verbosity; the query and bodies are never logged
+func LoggingMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		start := time.Now()
+		response, err := next.RoundTrip(request)
+		latency := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			log.Logf(log.REQUEST, -1, "%s %s failed after %s: %v", request.Method, request.URL.Path, latency, err)
+		} else {
+			log.Logf(log.REQUEST, -1, "%s %s %d %s", request.Method, request.URL.Path, response.StatusCode, latency)
+		}
+		return response, err
+	})
+}
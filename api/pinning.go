@@ -0,0 +1,80 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/config"
+)
+
+// ErrPinMismatch is returned when the server certificate doesn't match the configured pins
+var ErrPinMismatch = errors.New("server certificate doesn't match the pinned public keys")
+
+// IsPinMismatch return whether err is due to the server certificate not matching the pins
+func IsPinMismatch(err error) bool {
+	return errors.Is(err, ErrPinMismatch)
+}
+
+// pinningMutex protect the pins recorded on first use
+var pinningMutex sync.Mutex
+
+// spkiPin return the pin of a certificate public key
+func spkiPin(crt *x509.Certificate) string {
+	hash := sha256.Sum256(crt.RawSubjectPublicKeyInfo)
+	return config.PinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// verifyPins check that one of the certificates of the verified chains match
+// a configured pin; it's used as tls.Config.VerifyPeerCertificate, after the
+// certificate has been validated against the certification authority
+func verifyPins(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return fmt.Errorf("%w: no verified certificate", ErrPinMismatch)
+	}
+
+	pinningMutex.Lock()
+	defer pinningMutex.Unlock()
+	pinning := config.Current().Run.TLS.Pinning
+
+	pins := pinning.PinList()
+	if len(pins) == 0 {
+		if !pinning.TrustOnFirstUse {
+			return nil
+		}
+		// first connection, the server is trusted and its key recorded
+		pin := spkiPin(verifiedChains[0][0])
+		log.Warningf("trusting the server public key on first use, pinned to %s", pin)
+		config.Update(func(c *config.Options) {
+			c.Run.TLS.Pinning.AddPin(pin)
+		})
+		err := config.SaveFile(func(file *config.Options) {
+			file.Run.TLS.Pinning.AddPin(pin)
+		})
+		if err != nil {
+			log.Warningf("unable to save the server pin: %v, it will be trusted again on next start", err)
+		}
+		return nil
+	}
+
+	var presented []string
+	for _, chain := range verifiedChains {
+		for _, crt := range chain {
+			pin := spkiPin(crt)
+			for _, expected := range pins {
+				if pin == expected {
+					return nil
+				}
+			}
+			presented = append(presented, pin)
+		}
+	}
+	log.Errorf("server public key changed: presented %s, pinned %s", strings.Join(presented, ", "), strings.Join(pins, ", "))
+	return fmt.Errorf("%w: presented %s", ErrPinMismatch, presented[0])
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/config"
+)
+
+// ErrProxyRequired is returned instead of contacting the server directly
+// when the proxy is required but none apply
+var ErrProxyRequired = errors.New("a proxy is required but none is configured for this request")
+
+// proxyFunc return the function choosing the proxy of each request, as used
+// by http.Transport.Proxy; it return nil when requests are direct
+func proxyFunc(options *config.ProxyOptions) (proxy func(*http.Request) (*url.URL, error), err error) {
+	switch options.URL {
+	case "":
+		if options.Require {
+			return nil, ErrProxyRequired
+		}
+		return nil, nil
+	case config.ProxyEnvironment:
+		return func(request *http.Request) (proxyURL *url.URL, err error) {
+			if proxyURL, err = http.ProxyFromEnvironment(request); err != nil {
+				return nil, err
+			}
+			// an empty environment or NO_PROXY would make the request direct
+			if proxyURL == nil && options.Require {
+				return nil, ErrProxyRequired
+			}
+			return proxyURL, nil
+		}, nil
+	}
+
+	proxyURL, err := options.ProxyURL()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("contacting the api server through proxy %s://%s", proxyURL.Scheme, proxyURL.Host)
+	return http.ProxyURL(proxyURL), nil
+}
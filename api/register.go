@@ -3,6 +3,7 @@ package api
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/rand"
 	"crypto/x509"
@@ -14,16 +15,15 @@ import (
 	"io/ioutil"
 	"net/http"
 
-	"github.com/krostar/nebulo-golib/log"
-	"github.com/krostar/nebulo-golib/tools/cert"
-
 	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/identity"
 	"github.com/krostar/nebulo-client-desktop/user"
 )
 
 // Register send a certificate signing request and store the signed certificate
-func (api *Server) Register(key crypto.PrivateKey) (newUser *user.User, err error) {
-	log.Debugln("doing Register call")
+func (api *Server) Register(ctx context.Context, key crypto.PrivateKey) (newUser *user.User, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.DefaultTimeout())
+	defer cancel()
 
 	if user.Logged != nil {
 		return nil, errors.New("user already logged, no need to register")
@@ -36,34 +36,39 @@ func (api *Server) Register(key crypto.PrivateKey) (newUser *user.User, err erro
 	}
 
 	// send that csr to the server and pray he accept to sign it
-	response, err := api.Post("user", http.StatusCreated, CONTENT_TYPE_PEM, bytes.NewReader(csr))
+	response, err := api.Post(ctx, "user", http.StatusCreated, CONTENT_TYPE_PEM, bytes.NewReader(csr))
 	if err != nil {
-		return nil, fmt.Errorf("unable to get response: %v", err)
+		return nil, fmt.Errorf("unable to get response: %w", err)
 	}
 
 	// the response is supposed to contain the signed certificate
 	defer response.Body.Close() // nolint: errcheck
 	raw, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read response data: %v", err)
+		return nil, fmt.Errorf("unable to read response data: %w", err)
 	}
 
 	// save it
-	if err = ioutil.WriteFile(config.Config.Run.TLS.Cert, raw, 0400); err != nil {
-		return nil, fmt.Errorf("unable to write identity cert file %q: %v", config.Config.Run.TLS.Cert, err)
+	if err = ioutil.WriteFile(config.Current().Run.TLS.Cert, raw, 0400); err != nil {
+		return nil, fmt.Errorf("unable to write identity cert file %q: %v", config.Current().Run.TLS.Cert, err)
 	}
-	if err = config.SaveFile(); err != nil {
-		return nil, fmt.Errorf("unable to save configuration file: %v", err)
+	if err = config.SaveFile(saveIdentity); err != nil {
+		return nil, fmt.Errorf("unable to save configuration file: %w", err)
 	}
 
 	// try to log with this certificate
-	if err = changeTLSOptions(API, &config.Config.Run.TLS); err != nil {
-		return nil, fmt.Errorf("unable to change tls options to register: %v", err)
+	if err = changeTLSOptions(API, &config.Current().Run.TLS); err != nil {
+		return nil, fmt.Errorf("unable to change tls options to register: %w", err)
 	}
-	return api.Login()
+	return api.Login(ctx)
 }
 
 func (api *Server) createCSR(key crypto.PrivateKey) (_ []byte, err error) {
+	identityKey, err := identity.NewPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
 	subj := pkix.Name{
 		CommonName:         "nebulo-client",
 		Country:            []string{"-"},
@@ -75,42 +80,53 @@ func (api *Server) createCSR(key crypto.PrivateKey) (_ []byte, err error) {
 
 	asn1Subj, err := asn1.Marshal(subj.ToRDNSequence())
 	if err != nil {
-		return nil, fmt.Errorf("unable to marshal asn1: %v", err)
+		return nil, fmt.Errorf("unable to marshal asn1: %w", err)
 	}
 
 	template := x509.CertificateRequest{
 		RawSubject:         asn1Subj,
-		SignatureAlgorithm: x509.SHA512WithRSA,
+		SignatureAlgorithm: identityKey.SignatureAlgorithm(),
 	}
 
-	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &template, key)
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &template, identityKey.Signer())
 	if err != nil {
-		return nil, fmt.Errorf("unable to create csr: %v", err)
+		return nil, fmt.Errorf("unable to create csr: %w", err)
 	}
 
 	var b bytes.Buffer
 	bb := bufio.NewWriter(&b)
 	if err = pem.Encode(bb, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes}); err != nil {
-		return nil, fmt.Errorf("unable to encode pem: %v", err)
+		return nil, fmt.Errorf("unable to encode pem: %w", err)
 	}
 	if err = bb.Flush(); err != nil {
-		return nil, fmt.Errorf("unable to flush buffer: %v", err)
+		return nil, fmt.Errorf("unable to flush buffer: %w", err)
 	}
 	return b.Bytes(), nil
 }
 
 // RegisterWithKeyPairFilename do the same thing as Register but with key path and password
-func (api *Server) RegisterWithKeyPairFilename(privateKeyFilepath string, privateKeyPassword []byte) (_ *user.User, err error) {
-	key, err := cert.ParsePrivateKeyPEMFromFile(privateKeyFilepath, privateKeyPassword)
+func (api *Server) RegisterWithKeyPairFilename(ctx context.Context, privateKeyFilepath string, privateKeyPassword []byte) (_ *user.User, err error) {
+	return api.RegisterWithIdentity(ctx, identity.Source{
+		Store:    identity.StorePEM,
+		Key:      privateKeyFilepath,
+		Password: string(privateKeyPassword),
+	})
+}
+
+// RegisterWithIdentity do the same thing as Register but with a key from any store,
+// the signed certificate is written in the configured certificate file
+func (api *Server) RegisterWithIdentity(ctx context.Context, source identity.Source) (_ *user.User, err error) {
+	if source.Store == identity.StorePKCS12 {
+		return nil, errors.New("a PKCS#12 bundle already contain a certificate, log in with it instead")
+	}
+	source.Cert = ""
+	id, err := identity.Load(source)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get key from file: %v", err)
+		return nil, fmt.Errorf("unable to get key from identity: %w", err)
 	}
 
-	config.Config.Run.TLS.Key = privateKeyFilepath
-	config.Config.Run.TLS.KeyPassword = string(privateKeyPassword)
-	// if err = changeTLSOptions(API, &config.Config.Run.TLS); err != nil {
-	// 	return nil, fmt.Errorf("unable to change tls options to register: %v", err)
-	// }
-
-	return api.Register(key)
+	config.Update(func(c *config.Options) {
+		user.SetIdentitySource(&c.Run.TLS, source)
+	})
+	return api.Register(ctx, id.Key.Signer())
 }
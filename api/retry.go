@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// retryAttempts is the maximum number of attempts of an idempotent request
+	retryAttempts = 4
+	retryDelayMin = 500 * time.Millisecond
+	retryDelayMax = 10 * time.Second
+	// retryAfterMax bound the delay asked by the server with Retry-After
+	retryAfterMax = time.Minute
+)
+
+// retryable return whether a request can be sent again without side effect
+func retryable(request *http.Request) bool {
+	return request.Method == http.MethodGet || request.Method == http.MethodHead
+}
+
+// unreachable return whether the server could not handle the request, so the request
+// could be retried; it's the case on network errors and on overload statuses,
+// but not when the server presented an unexpected certificate, refused the
+// identity, or when the request has been refused for lack of proxy
+func unreachable(response *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrPinMismatch) && !errors.Is(err, ErrProxyRequired) && !identityRejected(err)
+	}
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay return a random delay to wait after the nth failed attempt,
+// the delay grows exponentially and is jittered to spread the retries
+func retryDelay(attempt int) time.Duration {
+	ceiling := retryDelayMin << uint(attempt-1)
+	if ceiling > retryDelayMax || ceiling <= 0 {
+		ceiling = retryDelayMax
+	}
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+}
+
+// retryAfter return the delay asked by the server with the Retry-After header
+// on 429 and 503 responses, 0 when there is none
+func retryAfter(response *http.Response) time.Duration {
+	if response == nil || (response.StatusCode != http.StatusTooManyRequests && response.StatusCode != http.StatusServiceUnavailable) {
+		return 0
+	}
+	header := response.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(header); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		delay = time.Until(date)
+	}
+	if delay < 0 {
+		delay = 0
+	} else if delay > retryAfterMax {
+		delay = retryAfterMax
+	}
+	return delay
+}
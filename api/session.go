@@ -0,0 +1,51 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/user"
+)
+
+// session track the authentication of the logged user
+type session struct {
+	mutex  sync.Mutex
+	onLost func(err error)
+}
+
+// OnSessionLost register a function called once the server refuse the
+// identity of the logged user, for example when its certificate has been
+// revoked; it's called at most once and has to be registered after each login
+func (api *Server) OnSessionLost(onLost func(err error)) {
+	api.session.mutex.Lock()
+	defer api.session.mutex.Unlock()
+	api.session.onLost = onLost
+}
+
+// sessionLost call the registered function, if any, and forget it so
+// the failure of the concurrent calls is reported once
+func (api *Server) sessionLost(err error) {
+	api.session.mutex.Lock()
+	onLost := api.session.onLost
+	api.session.onLost = nil
+	api.session.mutex.Unlock()
+
+	if onLost != nil {
+		log.Warningf("session lost: %v", err)
+		onLost(err)
+	}
+}
+
+// Logout disconnect the logged user and stop using its certificate
+func (api *Server) Logout() (err error) {
+	api.OnSessionLost(nil)
+	user.Logout()
+
+	tlsOptions := config.Current().Run.TLS
+	tlsOptions.Cert = ""
+	tlsOptions.Key = ""
+	tlsOptions.KeyPassword = ""
+	return changeTLSOptions(api, &tlsOptions)
+}
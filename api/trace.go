@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTraceEntries is the number of requests kept in a trace, the oldest are dropped
+const maxTraceEntries = 500
+
+// redacted replace the values which must not end up in a bug report
+const redacted = "[redacted]"
+
+// sensitiveHeaders are the headers whose values are never recorded
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// sensitiveWords mark query parameters and headers whose values are never recorded
+var sensitiveWords = []string{"key", "token", "secret", "password", "pin"}
+
+// Trace record the requests made to the server in a HAR-like format,
+// without bodies, credentials nor keys, to be attached to bug reports
+type Trace struct {
+	mutex   sync.Mutex
+	client  string
+	entries []TraceEntry
+}
+
+// TraceHeader is a recorded header or query parameter
+type TraceHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// TraceRequest is a recorded request, its body is only described
+type TraceRequest struct {
+	Method      string        `json:"method"`
+	URL         string        `json:"url"`
+	HTTPVersion string        `json:"httpVersion"`
+	Headers     []TraceHeader `json:"headers"`
+	QueryString []TraceHeader `json:"queryString"`
+	BodySize    int64         `json:"bodySize"`
+}
+
+// TraceResponse is a recorded response, its body is only described
+type TraceResponse struct {
+	Status      int           `json:"status"`
+	StatusText  string        `json:"statusText"`
+	HTTPVersion string        `json:"httpVersion"`
+	Headers     []TraceHeader `json:"headers"`
+	Content     struct {
+		Size     int64  `json:"size"`
+		MimeType string `json:"mimeType"`
+	} `json:"content"`
+	BodySize int64 `json:"bodySize"`
+}
+
+// TraceEntry is a recorded request and its response
+type TraceEntry struct {
+	StartedDateTime time.Time     `json:"startedDateTime"`
+	Time            float64       `json:"time"` // milliseconds
+	Request         TraceRequest  `json:"request"`
+	Response        TraceResponse `json:"response"`
+	Comment         string        `json:"comment,omitempty"` // the error, if any
+}
+
+// NewTrace create an empty trace of the requests made by client
+func NewTrace(client string) *Trace {
+	return &Trace{client: client}
+}
+
+// Middleware record each request in the trace
+func (t *Trace) Middleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		start := time.Now()
+		response, err := next.RoundTrip(request)
+
+		entry := TraceEntry{
+			StartedDateTime: start,
+			Time:            float64(time.Since(start)) / float64(time.Millisecond),
+			Request:         traceRequest(request),
+		}
+		if err != nil {
+			entry.Comment = err.Error()
+		} else {
+			entry.Response = traceResponse(response)
+		}
+		t.add(entry)
+		return response, err
+	})
+}
+
+// add append an entry, dropping the oldest one when the trace is full
+func (t *Trace) add(entry TraceEntry) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.entries) >= maxTraceEntries {
+		t.entries = t.entries[1:]
+	}
+	t.entries = append(t.entries, entry)
+}
+
+// WriteFile write the recorded requests to filepath, as a HAR document
+func (t *Trace) WriteFile(filepath string) (err error) {
+	name, version := t.client, ""
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name, version = name[:i], name[i+1:]
+	}
+
+	t.mutex.Lock()
+	har := map[string]interface{}{
+		"log": map[string]interface{}{
+			"version": "1.2",
+			"creator": map[string]string{"name": name, "version": version},
+			"entries": append([]TraceEntry{}, t.entries...),
+		},
+	}
+	t.mutex.Unlock()
+
+	raw, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode trace: %v", err)
+	}
+	if err = ioutil.WriteFile(filepath, raw, 0600); err != nil {
+		return fmt.Errorf("unable to write trace file %q: %v", filepath, err)
+	}
+	return nil
+}
+
+// traceRequest describe a request, without its body nor secrets
+func traceRequest(request *http.Request) TraceRequest {
+	u := *request.URL
+	u.User = nil
+	u.RawQuery = ""
+	return TraceRequest{
+		Method:      request.Method,
+		URL:         u.String(),
+		HTTPVersion: request.Proto,
+		Headers:     traceHeaders(request.Header),
+		QueryString: traceQuery(request.URL.Query()),
+		BodySize:    request.ContentLength,
+	}
+}
+
+// traceResponse describe a response, without its body nor secrets
+func traceResponse(response *http.Response) (r TraceResponse) {
+	r.Status = response.StatusCode
+	r.StatusText = http.StatusText(response.StatusCode)
+	r.HTTPVersion = response.Proto
+	r.Headers = traceHeaders(response.Header)
+	r.Content.Size = response.ContentLength
+	r.Content.MimeType = response.Header.Get("Content-Type")
+	r.BodySize = response.ContentLength
+	return r
+}
+
+func traceHeaders(header http.Header) []TraceHeader {
+	headers := make([]TraceHeader, 0, len(header))
+	for name, values := range header {
+		for _, value := range values {
+			if sensitiveHeaders[http.CanonicalHeaderKey(name)] || isSensitive(name) {
+				value = redacted
+			}
+			headers = append(headers, TraceHeader{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+func traceQuery(query url.Values) []TraceHeader {
+	params := make([]TraceHeader, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			if isSensitive(name) {
+				value = redacted
+			}
+			params = append(params, TraceHeader{Name: name, Value: value})
+		}
+	}
+	return params
+}
+
+// isSensitive return whether a name hint its value is a secret
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range sensitiveWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/krostar/nebulo-client-desktop/config"
+)
+
+// TransportMetrics count how the connections to the server have been used
+type TransportMetrics struct {
+	Requests    uint64 // requests which got a connection
+	Connections uint64 // connections established
+	Reused      uint64 // requests sent on an already used connection
+	HTTP2       uint64 // responses received with http/2
+}
+
+func (m TransportMetrics) String() string {
+	reuse := 0.0
+	if m.Requests > 0 {
+		reuse = float64(m.Reused) * 100 / float64(m.Requests)
+	}
+	return fmt.Sprintf("%d requests, %d connections, %d reused (%.0f%%), %d over http/2",
+		m.Requests, m.Connections, m.Reused, reuse, m.HTTP2)
+}
+
+// transportMetrics is updated concurrently by the requests
+type transportMetrics struct {
+	requests    uint64
+	connections uint64
+	reused      uint64
+	http2       uint64
+}
+
+// trace return the hooks counting the connections used by a request
+func (m *transportMetrics) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				atomic.AddUint64(&m.connections, 1)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			atomic.AddUint64(&m.requests, 1)
+			if info.Reused {
+				atomic.AddUint64(&m.reused, 1)
+			}
+		},
+	}
+}
+
+// response count the protocol of a response
+func (m *transportMetrics) response(response *http.Response) {
+	if response != nil && response.ProtoMajor == 2 {
+		atomic.AddUint64(&m.http2, 1)
+	}
+}
+
+// Metrics return the usage of the connections to the server since the start
+func (api *Server) Metrics() TransportMetrics {
+	return TransportMetrics{
+		Requests:    atomic.LoadUint64(&api.metrics.requests),
+		Connections: atomic.LoadUint64(&api.metrics.connections),
+		Reused:      atomic.LoadUint64(&api.metrics.reused),
+		HTTP2:       atomic.LoadUint64(&api.metrics.http2),
+	}
+}
+
+// newTransport create the transport used for the whole life of the client,
+// unless the tls material or the proxy change
+func newTransport(tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	options := &config.Current().Run.Transport
+	dialer := &net.Dialer{
+		Timeout:   options.DialTimeoutDuration(),
+		KeepAlive: options.KeepAliveDuration(),
+	}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   options.TLSHandshakeTimeoutDuration(),
+		ForceAttemptHTTP2:     !options.DisableHTTP2,
+		MaxIdleConns:          options.MaxIdleConnsCount(),
+		MaxIdleConnsPerHost:   options.MaxIdleConnsCount(), // there is only one server
+		MaxConnsPerHost:       options.MaxConnsPerHost,
+		IdleConnTimeout:       options.IdleConnTimeoutDuration(),
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// transportKey return a hash of everything the transport is built from, to
+// know whether it has to be built again; unreadable files are reported when
+// the tls configuration is created
+func transportKey(tlsOptions *config.TLSOptions) string {
+	hash := sha256.New()
+	for _, filepath := range []string{tlsOptions.ClientsCACert, tlsOptions.Cert, tlsOptions.Key} {
+		raw, err := ioutil.ReadFile(filepath)
+		fmt.Fprintf(hash, "%q %v %x\n", filepath, err, sha256.Sum256(raw)) // nolint: errcheck
+	}
+	run := config.Current().Run
+	fmt.Fprintf(hash, "%q\n%q\n%+v\n", tlsOptions.Store, tlsOptions.KeyPassword, tlsOptions.PKCS11) // nolint: errcheck
+	fmt.Fprintf(hash, "%+v\n%+v\n", run.Proxy, run.Transport)                                       // nolint: errcheck
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
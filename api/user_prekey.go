@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/user"
+)
+
+type userPrekeyUpdateRequest struct {
+	Prekey    []byte `json:"prekey"`
+	Signature []byte `json:"signature"` // of prekeySignedData, by the user
+}
+
+// UserPrekeyUpdate sign the prekey of the logged user and publish it in his profile,
+// the signature is returned
+func (api *Server) UserPrekeyUpdate(ctx context.Context, prekey []byte) (signature []byte, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.DefaultTimeout())
+	defer cancel()
+
+	// the others only wrap chains for prekeys signed by their owner
+	pKey, err := user.PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	if signature, err = pKey.Sign(prekeySignedData(user.Logged.KeyFingerprint, prekey)); err != nil {
+		return nil, fmt.Errorf("unable to sign prekey: %w", err)
+	}
+
+	requestBody, err := json.Marshal(&userPrekeyUpdateRequest{Prekey: prekey, Signature: signature})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal json: %w", err)
+	}
+
+	_, err = api.Post(ctx, "user/prekey", http.StatusOK, CONTENT_TYPE_JSON, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("unable to get response: %w", err)
+	}
+	return signature, nil
+}
+
+// verifyPrekey check the published prekey of a user has been signed by him
+func verifyPrekey(u *user.User) (err error) {
+	if len(u.Prekey) == 0 {
+		return errors.New("no prekey published")
+	}
+	pubKey, err := u.PublicKey()
+	if err != nil {
+		return fmt.Errorf("unable to parse public key of %q: %w", u.KeyFingerprint, err)
+	}
+	if err = pubKey.Verify(prekeySignedData(u.KeyFingerprint, u.Prekey), u.PrekeySignature); err != nil {
+		return fmt.Errorf("invalid prekey signature: %w", err)
+	}
+	return nil
+}
+
+// prekeySignedData bind a prekey to its owner
+func prekeySignedData(fingerprint string, prekey []byte) []byte {
+	return []byte(fmt.Sprintf("nebulo prekey:%s:%x", fingerprint, prekey))
+}
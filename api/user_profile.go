@@ -1,13 +1,13 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 
-	"github.com/krostar/nebulo-golib/log"
-
+	"github.com/krostar/nebulo-client-desktop/config"
 	"github.com/krostar/nebulo-client-desktop/user"
 )
 
@@ -15,22 +15,23 @@ import (
 type UserProfileResponse user.User
 
 // UserProfile return the user profile informations
-func (api *Server) UserProfile() (u *user.User, err error) {
-	log.Debugln("doing User Profile call")
+func (api *Server) UserProfile(ctx context.Context) (u *user.User, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.DefaultTimeout())
+	defer cancel()
 
-	response, err := api.Get("user", http.StatusOK, nil)
+	response, err := api.Get(ctx, "user", http.StatusOK, nil)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get response: %v", err)
+		return nil, fmt.Errorf("unable to get response: %w", err)
 	}
 	defer response.Body.Close() // nolint: errcheck
 	raw, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read response data: %v", err)
+		return nil, fmt.Errorf("unable to read response data: %w", err)
 	}
 
 	upr := &UserProfileResponse{}
 	if err = json.Unmarshal(raw, upr); err != nil {
-		return nil, fmt.Errorf("unable to parse response data: %v", err)
+		return nil, fmt.Errorf("unable to parse response data: %w", err)
 	}
 
 	return (*user.User)(upr), nil
@@ -1,39 +1,44 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/config"
 )
 
 // VersionResponse is the response format wanted from a /version call
 type VersionResponse struct {
-	Version string `json:"build_version"`
-	Time    string `json:"build_time"`
+	Version      string   `json:"build_version"`
+	Time         string   `json:"build_time"`
+	Capabilities []string `json:"capabilities,omitempty"` // absent on old servers
 }
 
 // Version return the server versions informations
-func (api *Server) Version() (version *VersionResponse, err error) {
-	log.Debugln("doing Version call")
+func (api *Server) Version(ctx context.Context) (version *VersionResponse, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Run.Timeouts.DefaultTimeout())
+	defer cancel()
 
-	response, err := api.Get("version", http.StatusOK, nil)
+	response, err := api.Get(ctx, "version", http.StatusOK, nil)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get response: %v", err)
+		return nil, fmt.Errorf("unable to get response: %w", err)
 	}
 
 	defer response.Body.Close() // nolint: errcheck
 	raw, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read response data: %v", err)
+		return nil, fmt.Errorf("unable to read response data: %w", err)
 	}
 
 	vr := &VersionResponse{}
 	if err = json.Unmarshal(raw, vr); err != nil {
 		log.Debugf("ERROR VERSION: %v", err)
-		return nil, fmt.Errorf("unable to parse response data: %v", err)
+		return nil, fmt.Errorf("unable to parse response data: %w", err)
 	}
 
 	return vr, nil
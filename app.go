@@ -1,14 +1,19 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/krostar/nebulo-client-desktop/api"
 	"github.com/krostar/nebulo-client-desktop/config"
 	"github.com/krostar/nebulo-client-desktop/gui"
+	"github.com/krostar/nebulo-client-desktop/gui/view"
+	"github.com/krostar/nebulo-client-desktop/outbox"
 	"github.com/krostar/nebulo-golib/log"
 
 	cli "gopkg.in/urfave/cli.v2"
@@ -19,6 +24,9 @@ var (
 	BuildTime = "undefined"
 	// BuildVersion is the version of the binary (git tag or revision)
 	BuildVersion = "undefined"
+
+	// firstRun is set when no configuration file has been found
+	firstRun bool
 )
 
 func main() {
@@ -27,20 +35,27 @@ func main() {
 		Usage:       "encrypted chat",
 		HideVersion: true,
 		Before: func(c *cli.Context) (err error) {
-			if err = config.ApplyLoggingOptions(&config.Config.Global.Logging); err != nil {
+			if err = config.ApplyLoggingOptions(&config.Current().Global.Logging); err != nil {
 				return fmt.Errorf("unable to apply logging configuration: %v", err)
 			}
+			config.LoadDefaults()
 			if configFile := c.String("config"); configFile != "" {
 				if err = config.LoadFile(configFile); err != nil {
 					return fmt.Errorf("unable to load configuration file %q:%v", configFile, err)
 				}
+			} else if firstRun, err = config.LoadDefaultFile(); err != nil {
+				return fmt.Errorf("unable to load configuration file %q:%v", config.Filepath, err)
+			}
+			if err = config.LoadEnv(); err != nil {
+				return fmt.Errorf("unable to load configuration from environment: %v", err)
 			}
 			return nil
 		}, Flags: []cli.Flag{ // global flags (config and logs purpose)
 			&cli.StringFlag{
-				Name:    "config",
-				Aliases: []string{"c"},
-				Usage:   "path to the configuration file",
+				Name:        "config",
+				Aliases:     []string{"c"},
+				Usage:       "path to the configuration file (json, yaml or toml)",
+				DefaultText: "config.json, .yaml or .toml in $XDG_CONFIG_HOME/nebulo",
 			}, &cli.StringFlag{
 				Name:        "log",
 				Aliases:     []string{"l"},
@@ -72,12 +87,78 @@ func main() {
 						Name:        "tls-key",
 						Usage:       "* tls certificate key used with --tls-crt",
 						Destination: &config.CLI.Run.TLS.Key,
+					}, &cli.StringFlag{
+						Name:        "tls-store",
+						Usage:       "where the identity is kept: pem files, a pkcs12 bundle given with --tls-crt or a pkcs11 token",
+						DefaultText: "pem",
+						Destination: &config.CLI.Run.TLS.Store,
+					}, &cli.StringFlag{
+						Name:        "pkcs11-module",
+						Usage:       "PKCS#11 module of the token keeping the identity, with --tls-store pkcs11",
+						Destination: &config.CLI.Run.TLS.PKCS11.Module,
+					}, &cli.StringFlag{
+						Name:        "pkcs11-token",
+						Usage:       "label of the token keeping the identity",
+						Destination: &config.CLI.Run.TLS.PKCS11.TokenLabel,
+					}, &cli.StringFlag{
+						Name:        "pkcs11-key",
+						Usage:       "label of the identity key on the token",
+						Destination: &config.CLI.Run.TLS.PKCS11.KeyLabel,
 					}, &cli.StringFlag{
 						Name:        "tls-clients-ca",
 						Usage:       "* tls certification authority used to validate clients certificate for the tls mutual authentication",
 						Destination: &config.CLI.Run.TLS.ClientsCACert,
+					}, &cli.BoolFlag{
+						Name:        "forward-secrecy",
+						Usage:       "encrypt messages with ratchet chains when every channel member published a prekey, received messages can only be read until the client is closed",
+						Destination: &config.CLI.Run.ForwardSecrecy,
+					}, &cli.BoolFlag{
+						Name:        "allow-unversioned-server",
+						Usage:       "talk to a server without a valid version, like a development build, as if it was up to date",
+						Destination: &config.CLI.Run.AllowUnversionedServer,
+					}, &cli.StringFlag{
+						Name:        "timeout",
+						Usage:       "deadline of the calls to the API server, like 30s or 1m",
+						DefaultText: config.DefaultTimeout.String(),
+						Destination: &config.CLI.Run.Timeouts.Default,
+					}, &cli.StringFlag{
+						Name:        "tls-server-pins",
+						Usage:       "comma separated sha256/ prefixed base64 hashes of the public keys the API server certificate is pinned to",
+						Destination: &config.CLI.Run.TLS.Pinning.Pins,
+					}, &cli.BoolFlag{
+						Name:        "tls-trust-on-first-use",
+						Usage:       "pin the API server public key on the first connection when no pins are configured",
+						Destination: &config.CLI.Run.TLS.Pinning.TrustOnFirstUse,
+					}, &cli.StringFlag{
+						Name:        "proxy",
+						Usage:       "proxy used to contact the API server: an http://, https:// or socks5:// url, \"tor\" for a local tor daemon or \"environment\"",
+						Destination: &config.CLI.Run.Proxy.URL,
+					}, &cli.BoolFlag{
+						Name:        "proxy-require",
+						Usage:       "never contact the API server without proxy",
+						Destination: &config.CLI.Run.Proxy.Require,
+					}, &cli.BoolFlag{
+						Name:        "disable-http2",
+						Usage:       "only use http/1.1 to contact the API server",
+						Destination: &config.CLI.Run.Transport.DisableHTTP2,
+					}, &cli.StringFlag{
+						Name:        "trace-file",
+						Usage:       "path to a file where the requests made to the API server will be written at exit, without bodies nor secrets, to attach to bug reports",
+						Destination: &config.CLI.Run.TraceFile,
+					}, &cli.StringFlag{
+						Name:        "record-fixtures",
+						Usage:       "empty directory where the requests made to the API server and their responses will be recorded, certificates and ciphertexts included",
+						Destination: &config.CLI.Run.Fixtures.Record,
+					}, &cli.StringFlag{
+						Name:        "replay-fixtures",
+						Usage:       "directory of recorded fixtures to answer the requests with, instead of contacting the API server",
+						Destination: &config.CLI.Run.Fixtures.Replay,
+					}, &cli.StringFlag{
+						Name:        "ui-dir",
+						Usage:       "directory of .ui files used instead of the views embedded in the binary",
+						Destination: &config.CLI.Run.UIDir,
 					},
-				}, Before: beforeCommandWhoNeedMergeConfiguration,
+				}, Before: beforeCommandRun,
 				Action: commandRun,
 			}, &cli.Command{ // config-gen command, she generate an empty configuration file
 				Name:  "config-gen",
@@ -88,9 +169,24 @@ func main() {
 						Aliases:     []string{"d"},
 						Usage:       "path to a file where the configuration will be writted",
 						DefaultText: "standart output",
+					}, &cli.StringFlag{
+						Name:        "format",
+						Aliases:     []string{"f"},
+						Usage:       fmt.Sprintf("format of the configuration (%s)", strings.Join(config.Formats, ", ")),
+						DefaultText: "destination extension, or json",
 					},
 				}, Before: beforeEveryCommand,
 				Action: commandConfigGen,
+			}, &cli.Command{ // outbox command list the messages waiting to be sent
+				Name:  "outbox",
+				Usage: "list the messages waiting to be sent and quit",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "drop",
+						Usage: "id of a message to remove from the outbox, it will never be sent",
+					},
+				}, Before: beforeCommandWhoNeedMergeConfiguration,
+				Action: commandOutbox,
 			}, &cli.Command{ // version command output the current client version
 				Name:   "version",
 				Usage:  "display the version",
@@ -122,40 +218,103 @@ func beforeCommandWhoNeedMergeConfiguration(c *cli.Context) (err error) {
 	if err = config.Apply(); err != nil {
 		return fmt.Errorf("configuration application failed: %v", err)
 	}
-	log.Logf(log.DEBUG, -1, "Configuration merged, validated and applied: %v", config.Config)
+	log.Logf(log.DEBUG, -1, "Configuration merged, validated and applied: %v", config.Current())
+	return nil
+}
+
+// beforeCommandRun create the default directories and, on first run, ask
+// for the server to use before saving the configuration
+func beforeCommandRun(c *cli.Context) (err error) {
+	if err = config.CreateDirs(); err != nil {
+		return err
+	}
+	if firstRun {
+		config.Merge()
+		if config.Current().Run.BaseURL == "" || config.Current().Run.TLS.ClientsCACert == "" {
+			if config.File.Run.BaseURL, config.File.Run.TLS.ClientsCACert, err = gui.Setup(config.Current().Run.BaseURL, config.Current().Run.TLS.ClientsCACert); err != nil {
+				return fmt.Errorf("unable to set up the client: %v", err)
+			}
+		}
+	}
+	if err = beforeCommandWhoNeedMergeConfiguration(c); err != nil {
+		return err
+	}
+	// only the server set up is saved, the defaults would otherwise be
+	// written in the file and never follow their changes
+	if firstRun {
+		if err = config.SaveFile(nil); err != nil {
+			return fmt.Errorf("unable to save configuration: %v", err)
+		}
+		log.Infof("configuration saved in %q", config.Filepath)
+	}
 	return nil
 }
 
 func commandRun(_ *cli.Context) error {
-	log.Infof("Starting Nebulo client build %s (%s): %s", BuildVersion, BuildTime, config.Config.Run.BaseURL)
+	log.Infof("Starting Nebulo client build %s (%s): %s", BuildVersion, BuildTime, config.Current().Run.BaseURL)
 
 	// try to reach the api server
-	version, err := api.Initialize(BuildVersion, config.Config.Run.BaseURL, &config.Config.Run.TLS)
+	version, err := api.Initialize(context.Background(), BuildVersion, config.Current().Run.BaseURL, &config.Current().Run.TLS)
 	if err != nil {
+		if api.IsPinMismatch(err) {
+			gui.Alert("Unable to connect to %s: %s", config.Current().Run.BaseURL, view.PinMismatchMessage)
+		}
 		return fmt.Errorf("unable to initialize API client: %v", err)
 	}
-	log.Infof("Using server API %q version: %s (%s)", config.Config.Run.BaseURL, version.Version, version.Time)
+	log.Infof("Using server API %q version: %s (%s)", config.Current().Run.BaseURL, version.Version, version.Time)
 
 	// start the GUI
 	return gui.GUI()
 }
 
 func commandConfigGen(c *cli.Context) error {
-	if filepath := c.String("destination"); filepath != "" {
-		config.Filepath = filepath
-		if err := config.SaveFile(); err != nil {
-			return fmt.Errorf("unable to write sql queries file: %v", err)
+	filepath, format := c.String("destination"), c.String("format")
+	if format == "" {
+		format = config.FormatFromPath(filepath)
+	}
+	conf, err := config.Marshal(config.Current(), format)
+	if err != nil {
+		return fmt.Errorf("unable to create configuration: %v", err)
+	}
+	if filepath != "" {
+		if err = ioutil.WriteFile(filepath, conf, 0600); err != nil {
+			return fmt.Errorf("unable to write configuration file %q: %v", filepath, err)
 		}
 	} else {
-		conf, err := json.MarshalIndent(config.Config, "", "    ")
-		if err != nil {
-			return fmt.Errorf("unable to create json: %v", err)
-		}
-		fmt.Println(string(conf))
+		fmt.Println(strings.TrimSuffix(string(conf), "\n"))
 	}
 	return nil
 }
 
+func commandOutbox(c *cli.Context) (err error) {
+	// list the messages of every identity, the sender column tells them apart
+	if err = outbox.Load(config.Current().Run.OutboxFile, ""); err != nil {
+		return fmt.Errorf("unable to load outbox: %v", err)
+	}
+
+	if id := c.String("drop"); id != "" {
+		if err = outbox.Drop(id); err != nil {
+			return fmt.Errorf("unable to drop message: %v", err)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCHANNEL\tSENDER\tCREATED\tSTATUS\tATTEMPTS\tNEXT ATTEMPT\tERROR") // nolint: errcheck
+	for _, m := range outbox.List() {
+		nextAttempt := "-"
+		if m.Status == outbox.StatusFailed {
+			nextAttempt = m.NextAttempt.Local().Format(time.RFC3339)
+		}
+		sender := m.Sender
+		if sender == "" {
+			sender = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", // nolint: errcheck
+			m.ID, m.ChannelName, sender, m.Created.Local().Format(time.RFC3339), m.Status, m.Attempts, nextAttempt, m.Error)
+	}
+	return w.Flush()
+}
+
 func commandVersion(_ *cli.Context) error {
 	fmt.Printf("nebulo %s (%s)\n", BuildVersion, BuildTime)
 	return nil
@@ -0,0 +1,78 @@
+package attachment
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/krostar/nebulo-client-desktop/symmetric"
+)
+
+// MaxSize is the maximum size of a file to attach
+const MaxSize = 20 << 20
+
+// Attachment describe a file attached to a message, it is sent inside the
+// encrypted message so only the channel members can decrypt the file
+type Attachment struct {
+	ID     string `json:"id"` // server identifier of the encrypted file
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	MIME   string `json:"mime"`
+	Key    []byte `json:"key"`    // random key used to encrypt the file
+	Digest []byte `json:"sha256"` // digest of the encrypted file
+}
+
+// FromFile read and encrypt a file with a random key, the returned
+// ciphertext is the content to upload
+func FromFile(path string) (a *Attachment, ciphertext []byte, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to stat file %q: %v", path, err)
+	}
+	if info.Size() > MaxSize {
+		return nil, nil, fmt.Errorf("file %q is too big: %d bytes, maximum is %d", path, info.Size(), MaxSize)
+	}
+
+	plaintext, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read file %q: %v", path, err)
+	}
+
+	key, err := symmetric.NewKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err = symmetric.Seal(key, plaintext, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to encrypt file: %v", err)
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(plaintext)
+	}
+	digest := sha256.Sum256(ciphertext)
+
+	return &Attachment{
+		Name:   filepath.Base(path),
+		Size:   int64(len(plaintext)),
+		MIME:   mimeType,
+		Key:    key,
+		Digest: digest[:],
+	}, ciphertext, nil
+}
+
+// Open check the integrity of the downloaded ciphertext and decrypt it
+func (a *Attachment) Open(ciphertext []byte) (plaintext []byte, err error) {
+	digest := sha256.Sum256(ciphertext)
+	if !bytes.Equal(digest[:], a.Digest) {
+		return nil, errors.New("downloaded file digest mismatch")
+	}
+	return symmetric.Open(a.Key, ciphertext, nil)
+}
@@ -1,6 +1,8 @@
 package channel
 
 import (
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/krostar/nebulo-client-desktop/user"
@@ -15,4 +17,35 @@ type Channel struct {
 	MembersCanInvite bool        `json:"members_can_invite"`
 }
 
-var Channels map[string]*Channel
+var (
+	// channels of the logged user by name, replaced as a whole when the
+	// list is fetched again; read from the gtk main loop and the tasks
+	channels      = make(map[string]*Channel)
+	channelsMutex sync.RWMutex
+)
+
+// SetChannels replace the known channels of the logged user
+func SetChannels(list map[string]*Channel) {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+	channels = list
+}
+
+// Find return the channel with the provided name
+func Find(name string) (c *Channel, ok bool) {
+	channelsMutex.RLock()
+	defer channelsMutex.RUnlock()
+	c, ok = channels[name]
+	return c, ok
+}
+
+// Names return the sorted names of the known channels
+func Names() (names []string) {
+	channelsMutex.RLock()
+	defer channelsMutex.RUnlock()
+	for name := range channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
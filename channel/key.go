@@ -0,0 +1,110 @@
+package channel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/krostar/nebulo-client-desktop/symmetric"
+)
+
+// Key is a symmetric key shared by the members of a channel, messages
+// are encrypted once with it instead of once per member
+type Key struct {
+	ID      string
+	Secret  []byte
+	Members []string // fingerprints of the members the key has been distributed to
+	Created time.Time
+}
+
+var (
+	// keys store the known keys of each channel, by key id
+	keys      = make(map[string]map[string]*Key)
+	keysMutex sync.Mutex
+)
+
+// NewKey generate a new channel key for the provided members fingerprints
+func NewKey(members []string) (k *Key, err error) {
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return nil, fmt.Errorf("unable to generate key id: %v", err)
+	}
+	secret, err := symmetric.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	sorted := append([]string{}, members...)
+	sort.Strings(sorted)
+
+	return &Key{
+		ID:      hex.EncodeToString(id),
+		Secret:  secret,
+		Members: sorted,
+		Created: time.Now(),
+	}, nil
+}
+
+// DistributedTo return whether the key has been distributed to exactly these members
+func (k *Key) DistributedTo(members []string) bool {
+	if len(k.Members) != len(members) {
+		return false
+	}
+	sorted := append([]string{}, members...)
+	sort.Strings(sorted)
+	for i := range sorted {
+		if sorted[i] != k.Members[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// AddKey store a key of a channel, its signature must have been verified
+func AddKey(channelName string, k *Key) {
+	keysMutex.Lock()
+	defer keysMutex.Unlock()
+
+	if keys[channelName] == nil {
+		keys[channelName] = make(map[string]*Key)
+	}
+	keys[channelName][k.ID] = k
+}
+
+// FindKey return the key of a channel with the provided id
+func FindKey(channelName string, id string) (k *Key, ok bool) {
+	keysMutex.Lock()
+	defer keysMutex.Unlock()
+
+	k, ok = keys[channelName][id]
+	return k, ok
+}
+
+// CurrentKey return the most recent key of a channel
+func CurrentKey(channelName string) (current *Key, ok bool) {
+	keysMutex.Lock()
+	defer keysMutex.Unlock()
+
+	for _, k := range keys[channelName] {
+		if current == nil || k.Created.After(current.Created) {
+			current = k
+		}
+	}
+	return current, current != nil
+}
+
+// HasKeys return whether keys of the channel are known
+func HasKeys(channelName string) bool {
+	keysMutex.Lock()
+	defer keysMutex.Unlock()
+	return len(keys[channelName]) > 0
+}
+
+// ForgetKeys remove every known channel keys, used when a user log in
+func ForgetKeys() {
+	keysMutex.Lock()
+	defer keysMutex.Unlock()
+	keys = make(map[string]map[string]*Key)
+}
@@ -11,11 +11,12 @@ import (
 // values from configuration
 func Apply() (err error) {
 	// check the configuration
-	if err = validator.Validate(Config); err != nil {
+	config := Current()
+	if err = validator.Validate(config); err != nil {
 		return err
 	}
 
-	if err = ApplyLoggingOptions(&Config.Global.Logging); err != nil {
+	if err = ApplyLoggingOptions(&config.Global.Logging); err != nil {
 		return fmt.Errorf("apply logging configuration failed: %v", err)
 	}
 
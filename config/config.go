@@ -1,10 +1,10 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"reflect"
+	"sync"
 
 	_ "github.com/krostar/nebulo-client-desktop/validator" // used to init custom validators before using them
 	"github.com/krostar/nebulo-golib/tools"
@@ -23,14 +23,54 @@ type runOptions struct {
 	TLS          TLSOptions `json:"tls"`
 	BaseURL      string     `json:"baseurl" validate:"string=nonempty"`
 	ContactsFile string     `json:"contacts_file" validate:"string=nonempty"`
+	OutboxFile   string     `json:"outbox_file" validate:"file=omitempty+writable"`
+
+	ForwardSecrecy bool   `json:"forward_secrecy"`
+	RatchetFile    string `json:"ratchet_file" validate:"file=omitempty+writable"`
+
+	// AllowUnversionedServer accept servers without a version, like development builds
+	AllowUnversionedServer bool `json:"allow_unversioned_server"`
+
+	Timeouts  TimeoutOptions   `json:"timeouts"`
+	Proxy     ProxyOptions     `json:"proxy"`
+	Transport TransportOptions `json:"transport"`
+	Fixtures  FixturesOptions  `json:"fixtures"`
+
+	// UIDir is a directory of .ui files replacing the view definitions embedded in the binary
+	UIDir string `json:"ui_dir"`
+
+	// TraceFile is where the requests made to the server are written at exit,
+	// without their bodies and secrets, to be attached to bug reports
+	TraceFile string `json:"trace_file" validate:"file=omitempty+writable"`
 }
 
 // TLSOptions store required TLS options
 type TLSOptions struct {
-	Key           string `json:"key" validate:"file=omitempty+readable"`
-	KeyPassword   string `json:"key_password"`
-	ClientsCACert string `json:"clients_ca_cert" validate:"file=readable"`
-	Cert          string `json:"cert" validate:"string=nonempty"`
+	// Store is where the identity is kept: "pem" files (the default), a
+	// "pkcs12" bundle in Cert, or a "pkcs11" token with the key password as PIN
+	Store         string        `json:"store" validate:"regexp=^(pem|pkcs12|pkcs11)?$"`
+	Key           string        `json:"key" validate:"file=omitempty+readable"`
+	KeyPassword   string        `json:"key_password"`
+	ClientsCACert string        `json:"clients_ca_cert" validate:"file=readable"`
+	Cert          string        `json:"cert" validate:"string=nonempty"`
+	PKCS11        PKCS11Options `json:"pkcs11"`
+
+	Pinning PinningOptions `json:"pinning"`
+}
+
+// StorePKCS11 is the store of the identities kept on a PKCS#11 token
+const StorePKCS11 = "pkcs11"
+
+// CopyIdentity replace the options locating the identity by the ones of from
+func (o *TLSOptions) CopyIdentity(from *TLSOptions) {
+	o.Store, o.Cert, o.Key, o.KeyPassword, o.PKCS11 = from.Store, from.Cert, from.Key, from.KeyPassword, from.PKCS11
+}
+
+// PKCS11Options store where to find an identity key on a PKCS#11 token
+type PKCS11Options struct {
+	Module     string `json:"module" validate:"file=omitempty+readable"`
+	TokenLabel string `json:"token_label"`
+	KeyLabel   string `json:"key_label"`
 }
 
 // Options list all the available configurations
@@ -40,40 +80,61 @@ type Options struct {
 }
 
 var (
-	// Config store the active merge configuration
-	Config = &Options{}
+	// running store the active merge configuration, see Current and Update
+	running      = &Options{}
+	runningMutex sync.RWMutex
+
 	// CLI store the configuration fetched from the console line
 	CLI = &Options{}
 	// File store the configuration fetched from an optional file
 	File = &Options{}
+	// Env store the configuration fetched from the NEBULO_* environment variables
+	Env = &Options{}
 
 	// Filepath is the path of the loaded configuration file
 	Filepath string
+
+	// fileMutex serialize the changes of config.File and its writes
+	fileMutex sync.Mutex
 )
 
-// LoadFile fill config.File with the configuration parsed from path
+// LoadFile fill config.File with the configuration parsed from path,
+// its format depend on its extension
 func LoadFile(filepath string) (err error) {
 	raw, err := ioutil.ReadFile(filepath)
 	if err != nil {
 		return fmt.Errorf("unable to read file %q: %v", filepath, err)
 	}
 
-	if err = json.Unmarshal(raw, File); err != nil {
-		return fmt.Errorf("unable to parse json file: %v", err)
+	if err = Unmarshal(raw, FormatFromPath(filepath), File); err != nil {
+		return fmt.Errorf("unable to parse configuration file: %v", err)
 	}
 
 	Filepath = filepath
 	return nil
 }
 
-// SaveFile save the current configuration to a file
-func SaveFile() (err error) {
+// SaveFile apply change to config.File, if any, and save it to the
+// configuration file in the format matching its extension; only what
+// comes from the file and the changes are saved, not the options given
+// by the command line, the environment or the defaults
+func SaveFile(change func(file *Options)) (err error) {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	if change != nil {
+		change(File)
+	}
 	if Filepath == "" {
 		return nil
 	}
-	conf, err := json.MarshalIndent(Config, "", "    ")
+	saved := *File
+	// the pin of a token is asked again instead of being written in clear
+	if saved.Run.TLS.Store == StorePKCS11 {
+		saved.Run.TLS.KeyPassword = ""
+	}
+	conf, err := Marshal(&saved, FormatFromPath(Filepath))
 	if err != nil {
-		return fmt.Errorf("unable to create json: %v", err)
+		return err
 	}
 	if err := ioutil.WriteFile(Filepath, conf, 0600); err != nil {
 		return fmt.Errorf("unable to write configuration file %q: %v", Filepath, err)
@@ -81,23 +142,70 @@ func SaveFile() (err error) {
 	return nil
 }
 
-// Merge fill config.Config based on config.CLI and config.File
-// File < CLI
+// Current return a copy of the active configuration; it's read from the
+// gtk main loop and the tasks while a reload can replace it
+func Current() *Options {
+	runningMutex.RLock()
+	defer runningMutex.RUnlock()
+	current := *running
+	return &current
+}
+
+// Update change the active configuration, without saving it
+func Update(change func(config *Options)) {
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+	change(running)
+}
+
+// Merge replace the active configuration by the one built from config.CLI,
+// config.Env, config.File and config.Defaults: Defaults < File < Env < CLI;
+// nothing is kept from the previous merge, like an option removed since
 func Merge() {
-	mergeRecursive(reflect.ValueOf(CLI).Elem(), reflect.ValueOf(File).Elem(), reflect.ValueOf(Config).Elem())
+	candidate := &Options{}
+	fileMutex.Lock()
+	merge(candidate, File)
+	fileMutex.Unlock()
+
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+	*running = *candidate
+}
+
+// layer is a source of options merged in the configuration
+type layer struct {
+	value reflect.Value
+	set   map[string]bool // options defined even with their zero value, by environment variable name
+}
+
+// merge fill config based on config.CLI, config.Env, file and config.Defaults
+func merge(config *Options, file *Options) {
+	mergeRecursive(reflect.ValueOf(config).Elem(), EnvPrefix, []layer{
+		{value: reflect.ValueOf(CLI).Elem()},
+		{value: reflect.ValueOf(Env).Elem(), set: envSet},
+		{value: reflect.ValueOf(file).Elem()},
+		{value: reflect.ValueOf(Defaults).Elem()},
+	})
 }
 
-func mergeRecursive(cli, file, config reflect.Value) {
+// mergeRecursive set config with the first defined value of the layers,
+// name is the environment variable name of config
+func mergeRecursive(config reflect.Value, name string, layers []layer) {
 	switch config.Kind() {
 	case reflect.Struct: // nested struct, we want to go deeper
 		for i := 0; i < config.NumField(); i++ {
-			mergeRecursive(cli.Field(i), file.Field(i), config.Field(i))
+			fields := make([]layer, len(layers))
+			for j, l := range layers {
+				fields[j] = layer{value: l.value.Field(i), set: l.set}
+			}
+			mergeRecursive(config.Field(i), envName(name, config.Type().Field(i)), fields)
 		}
 	default: // everything else, we want to copy/merge
-		if !tools.IsZeroOrNil(cli) && cli.String() != "" {
-			config.Set(cli)
-		} else if !tools.IsZeroOrNil(file) && file.String() != "" {
-			config.Set(file)
+		for _, l := range layers {
+			if l.set[name] || !tools.IsZeroOrNil(l.value) && l.value.String() != "" {
+				config.Set(l.value)
+				return
+			}
 		}
 	}
 }
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the
+// configuration file, followed by the upper-cased json path of the option,
+// like NEBULO_RUN_TLS_KEY_PASSWORD for run.tls.key_password
+const EnvPrefix = "NEBULO"
+
+// envSet record the environment variables defined, to override the
+// configuration file even with zero values like false or 0
+var envSet = make(map[string]bool)
+
+// LoadEnv fill config.Env with the configuration read from the environment
+func LoadEnv() (err error) {
+	envSet = make(map[string]bool)
+	return loadEnvRecursive(reflect.ValueOf(Env).Elem(), EnvPrefix)
+}
+
+// Environ return the options as environment variables, in the NAME='value'
+// form a shell can source, empty options are omitted; it's the "env" format
+// of the configuration generation
+func Environ(options *Options) (environ []string) {
+	environRecursive(reflect.ValueOf(options).Elem(), EnvPrefix, &environ)
+	return environ
+}
+
+// envName return the environment variable name of a struct field
+func envName(prefix string, field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	return prefix + "_" + strings.ToUpper(name)
+}
+
+func environRecursive(v reflect.Value, prefix string, environ *[]string) {
+	for i := 0; i < v.NumField(); i++ {
+		field, name := v.Field(i), envName(prefix, v.Type().Field(i))
+		if field.Kind() == reflect.Struct {
+			environRecursive(field, name, environ)
+		} else if value := fmt.Sprint(field.Interface()); value != "" {
+			*environ = append(*environ, name+"="+shellQuote(value))
+		}
+	}
+}
+
+// shellQuote quote a value for a posix shell, the value is kept as is
+// between single quotes, the single quotes are closed, escaped and reopened
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+func loadEnvRecursive(v reflect.Value, prefix string) (err error) {
+	for i := 0; i < v.NumField(); i++ {
+		field, name := v.Field(i), envName(prefix, v.Type().Field(i))
+		if field.Kind() == reflect.Struct {
+			if err = loadEnvRecursive(field, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid boolean %q in %s: %v", value, name, err)
+			}
+			field.SetBool(b)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid number %q in %s: %v", value, name, err)
+			}
+			field.SetInt(n)
+		default:
+			return fmt.Errorf("unsupported type %s for %s", field.Kind(), name)
+		}
+		envSet[name] = true
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package config
+
+// FixturesOptions store where the requests made to the api server are
+// recorded, or replayed from instead of contacting the server; they are
+// used to pin the wire format of the api across server upgrades
+type FixturesOptions struct {
+	Record string `json:"record"` // empty directory where the fixtures are written
+	Replay string `json:"replay"` // directory of fixtures served back
+}
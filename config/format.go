@@ -0,0 +1,121 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// configuration file formats
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+	// FormatEnv is only generated, as NEBULO_* environment variables
+	FormatEnv = "env"
+)
+
+// Formats list the formats a configuration can be generated in
+var Formats = []string{FormatJSON, FormatYAML, FormatTOML, FormatEnv}
+
+// FormatFromPath return the format of a configuration file based on its
+// extension, files without known extension are json
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// Unmarshal parse a configuration in any format; the json field names are
+// used for every format, yaml and toml are converted to json first
+func Unmarshal(raw []byte, format string, options *Options) (err error) {
+	if format != FormatJSON {
+		var document map[string]interface{}
+		switch format {
+		case FormatYAML:
+			err = yaml.Unmarshal(raw, &document)
+		case FormatTOML:
+			err = toml.Unmarshal(raw, &document)
+		default:
+			return fmt.Errorf("unknown configuration format %q", format)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse %s: %v", format, err)
+		}
+		if raw, err = json.Marshal(document); err != nil {
+			return fmt.Errorf("unable to convert %s to json: %v", format, err)
+		}
+	}
+	if err = json.Unmarshal(raw, options); err != nil {
+		return fmt.Errorf("unable to parse json: %v", err)
+	}
+	return nil
+}
+
+// Marshal write a configuration in any format, with the json field names
+func Marshal(options *Options, format string) (raw []byte, err error) {
+	if format == FormatEnv {
+		return []byte(strings.Join(Environ(options), "\n") + "\n"), nil
+	}
+	if raw, err = json.MarshalIndent(options, "", "    "); err != nil {
+		return nil, fmt.Errorf("unable to create json: %v", err)
+	}
+	if format == FormatJSON {
+		return raw, nil
+	}
+
+	// numbers are kept as integers, both format would write them as floats
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var document map[string]interface{}
+	if err = decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("unable to convert json: %v", err)
+	}
+	document = integers(document).(map[string]interface{})
+
+	buffer := new(bytes.Buffer)
+	switch format {
+	case FormatYAML:
+		encoder := yaml.NewEncoder(buffer)
+		encoder.SetIndent(4)
+		err = encoder.Encode(document)
+	case FormatTOML:
+		err = toml.NewEncoder(buffer).Encode(document)
+	default:
+		return nil, fmt.Errorf("unknown configuration format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s: %v", format, err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// integers replace the json numbers of a document by int64 or float64
+func integers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = integers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = integers(item)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64() // nolint: errcheck
+		return f
+	}
+	return value
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// appDirname is the name of the application directories, in each xdg base directory
+const appDirname = "nebulo"
+
+// filenames looked for in the configuration directory, in this order
+var defaultFilenames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// Defaults store the configuration used when neither the file, the
+// environment nor the cli set an option
+var Defaults = &Options{}
+
+// xdgDir return the application directory in an xdg base directory,
+// with its default relative to the home directory when the variable is unset
+func xdgDir(variable string, fallback ...string) string {
+	if dir := os.Getenv(variable); filepath.IsAbs(dir) {
+		return filepath.Join(dir, appDirname)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(append(append([]string{home}, fallback...), appDirname)...)
+}
+
+// ConfigDir return the directory of the configuration file
+func ConfigDir() string {
+	return xdgDir("XDG_CONFIG_HOME", ".config")
+}
+
+// DataDir return the directory of the contacts, the outbox and the ratchet states
+func DataDir() string {
+	return xdgDir("XDG_DATA_HOME", ".local", "share")
+}
+
+// KeysDir return the directory of the identity keys and certificates
+func KeysDir() string {
+	return filepath.Join(DataDir(), "keys")
+}
+
+// LoadDefaults fill config.Defaults with the default locations
+func LoadDefaults() {
+	Defaults.Run.ContactsFile = filepath.Join(DataDir(), "contacts.json")
+	Defaults.Run.OutboxFile = filepath.Join(DataDir(), "outbox.json")
+	Defaults.Run.RatchetFile = filepath.Join(DataDir(), "ratchet.json")
+	Defaults.Run.TLS.Cert = filepath.Join(KeysDir(), "identity.crt")
+}
+
+// LoadDefaultFile fill config.File with the configuration file of the
+// configuration directory; when there is none, it's the first run and
+// Filepath is set to where the configuration has to be saved
+func LoadDefaultFile() (firstRun bool, err error) {
+	for _, filename := range defaultFilenames {
+		path := filepath.Join(ConfigDir(), filename)
+		if _, err = os.Stat(path); err == nil {
+			return false, LoadFile(path)
+		}
+	}
+	Filepath = filepath.Join(ConfigDir(), defaultFilenames[0])
+	return true, nil
+}
+
+// CreateDirs create the default directories, only readable by the user
+func CreateDirs() (err error) {
+	for _, dir := range []string{ConfigDir(), DataDir(), KeysDir()} {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("unable to create directory %q: %v", dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package config
+
+import "strings"
+
+// PinPrefix is the prefix of the pins, followed by the base64 encoded
+// sha256 hash of a certificate subject public key info
+const PinPrefix = "sha256/"
+
+// PinningOptions store the public keys the api server certificate is pinned to
+type PinningOptions struct {
+	// Pins is a comma separated list of pins, any of them can match,
+	// which allow to rotate the server key
+	Pins string `json:"pins" validate:"pins"`
+	// TrustOnFirstUse record the pin of the server on the first connection
+	TrustOnFirstUse bool `json:"trust_on_first_use"`
+}
+
+// PinList return the configured pins
+func (p *PinningOptions) PinList() (pins []string) {
+	for _, pin := range strings.Split(p.Pins, ",") {
+		if pin = strings.TrimSpace(pin); pin != "" {
+			pins = append(pins, pin)
+		}
+	}
+	return pins
+}
+
+// AddPin add a pin to the configured ones
+func (p *PinningOptions) AddPin(pin string) {
+	p.Pins = strings.Join(append(p.PinList(), pin), ",")
+}
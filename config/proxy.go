@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// special values of ProxyOptions.URL
+const (
+	// ProxyTor use the socks proxy of a local tor daemon
+	ProxyTor = "tor"
+	// ProxyEnvironment use the proxy defined by the HTTPS_PROXY and NO_PROXY environment variables
+	ProxyEnvironment = "environment"
+
+	// TorProxyURL is the address of the socks proxy of a local tor daemon
+	TorProxyURL = "socks5://127.0.0.1:9050"
+)
+
+// ProxyOptions store the proxy used to contact the api server
+type ProxyOptions struct {
+	// URL is either an http://, https:// or socks5:// url, "tor" or
+	// "environment"; requests are direct when empty
+	URL string `json:"url" validate:"proxy"`
+	// Require refuse to contact the api server without proxy
+	Require bool `json:"require"`
+}
+
+// ProxyURL return the url of the configured proxy, nil when
+// requests are direct or when the proxy come from the environment
+func (p *ProxyOptions) ProxyURL() (proxyURL *url.URL, err error) {
+	switch p.URL {
+	case "", ProxyEnvironment:
+		return nil, nil
+	case ProxyTor:
+		return url.Parse(TorProxyURL)
+	}
+	if proxyURL, err = url.Parse(p.URL); err != nil {
+		return nil, fmt.Errorf("unable to parse proxy url %q: %v", p.URL, err)
+	}
+	return proxyURL, nil
+}
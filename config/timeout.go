@@ -0,0 +1,38 @@
+package config
+
+import "time"
+
+// DefaultTimeout is the deadline of the api calls when none is configured
+const DefaultTimeout = 30 * time.Second
+
+// TimeoutOptions store the deadlines of the api calls, in the format
+// of time.ParseDuration; empty values fall back to the default one
+type TimeoutOptions struct {
+	Default  string `json:"default" validate:"duration"`
+	Messages string `json:"messages" validate:"duration"`
+	Files    string `json:"files" validate:"duration"`
+}
+
+// DefaultTimeout return the deadline of the calls without a specific timeout
+func (t *TimeoutOptions) DefaultTimeout() time.Duration {
+	return parseTimeout(t.Default, DefaultTimeout)
+}
+
+// MessagesTimeout return the deadline of the calls listing or sending messages
+func (t *TimeoutOptions) MessagesTimeout() time.Duration {
+	return parseTimeout(t.Messages, t.DefaultTimeout())
+}
+
+// FilesTimeout return the deadline of the calls uploading or downloading files
+func (t *TimeoutOptions) FilesTimeout() time.Duration {
+	return parseTimeout(t.Files, t.DefaultTimeout())
+}
+
+// parseTimeout parse a validated duration, fallback is used when value is empty
+func parseTimeout(value string, fallback time.Duration) time.Duration {
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return fallback
+	}
+	return timeout
+}
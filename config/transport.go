@@ -0,0 +1,52 @@
+package config
+
+import "time"
+
+// default values of the transport options
+const (
+	DefaultDialTimeout         = 10 * time.Second
+	DefaultKeepAlive           = 30 * time.Second
+	DefaultIdleConnTimeout     = 90 * time.Second
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+	DefaultMaxIdleConns        = 10
+)
+
+// TransportOptions tune the connections to the api server, durations are
+// in the format of time.ParseDuration; empty values fall back to the defaults
+type TransportOptions struct {
+	DialTimeout         string `json:"dial_timeout" validate:"duration"`
+	KeepAlive           string `json:"keep_alive" validate:"duration"`
+	IdleConnTimeout     string `json:"idle_conn_timeout" validate:"duration"`
+	TLSHandshakeTimeout string `json:"tls_handshake_timeout" validate:"duration"`
+	MaxIdleConns        int    `json:"max_idle_conns" validate:"min=0"`
+	MaxConnsPerHost     int    `json:"max_conns_per_host" validate:"min=0"` // 0 means no limit
+	DisableHTTP2        bool   `json:"disable_http2"`
+}
+
+// DialTimeoutDuration return the deadline to establish a connection
+func (t *TransportOptions) DialTimeoutDuration() time.Duration {
+	return parseTimeout(t.DialTimeout, DefaultDialTimeout)
+}
+
+// KeepAliveDuration return the interval between keep-alive probes
+func (t *TransportOptions) KeepAliveDuration() time.Duration {
+	return parseTimeout(t.KeepAlive, DefaultKeepAlive)
+}
+
+// IdleConnTimeoutDuration return how long an idle connection is kept open
+func (t *TransportOptions) IdleConnTimeoutDuration() time.Duration {
+	return parseTimeout(t.IdleConnTimeout, DefaultIdleConnTimeout)
+}
+
+// TLSHandshakeTimeoutDuration return the deadline of the tls handshake
+func (t *TransportOptions) TLSHandshakeTimeoutDuration() time.Duration {
+	return parseTimeout(t.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout)
+}
+
+// MaxIdleConnsCount return the number of idle connections kept open
+func (t *TransportOptions) MaxIdleConnsCount() int {
+	if t.MaxIdleConns <= 0 {
+		return DefaultMaxIdleConns
+	}
+	return t.MaxIdleConns
+}
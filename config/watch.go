@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/krostar/nebulo-golib/log"
+	validator "gopkg.in/validator.v2"
+)
+
+// reloadDelay group the events of a file written in several steps
+const reloadDelay = 250 * time.Millisecond
+
+// Subscriber is called after the configuration has been reloaded with
+// the configuration which was running before, to reconfigure live
+type Subscriber func(previous Options)
+
+var subscribers = struct {
+	sync.Mutex
+	list []Subscriber
+}{list: []Subscriber{reloadLogging}}
+
+// Subscribe add a function called after each reload changing the configuration
+func Subscribe(subscriber Subscriber) {
+	subscribers.Lock()
+	defer subscribers.Unlock()
+	subscribers.list = append(subscribers.list, subscriber)
+}
+
+// reloadLogging apply the logging options when they changed
+func reloadLogging(previous Options) {
+	logging := Current().Global.Logging
+	if previous.Global.Logging == logging {
+		return
+	}
+	if err := ApplyLoggingOptions(&logging); err != nil {
+		log.Errorf("unable to apply reloaded logging configuration: %v", err)
+	}
+}
+
+// Reload read the configuration file again and replace the running
+// configuration when it's valid; the identity of the logged user is kept,
+// it changes by logging in again
+func Reload() (changed bool, err error) {
+	raw, err := ioutil.ReadFile(Filepath)
+	if err != nil {
+		return false, fmt.Errorf("unable to read file %q: %v", Filepath, err)
+	}
+	file := &Options{}
+	if err = Unmarshal(raw, FormatFromPath(Filepath), file); err != nil {
+		return false, fmt.Errorf("unable to parse configuration file: %v", err)
+	}
+
+	runningMutex.Lock()
+	candidate := &Options{}
+	merge(candidate, file)
+	candidate.Run.TLS.CopyIdentity(&running.Run.TLS)
+	if err = validator.Validate(candidate); err != nil {
+		runningMutex.Unlock()
+		return false, fmt.Errorf("invalid configuration, the running one is kept: %v", err)
+	}
+	previous := *running
+	*running = *candidate
+	runningMutex.Unlock()
+
+	fileMutex.Lock()
+	*File = *file
+	fileMutex.Unlock()
+	if reflect.DeepEqual(candidate, &previous) {
+		return false, nil
+	}
+
+	subscribers.Lock()
+	list := append([]Subscriber{}, subscribers.list...)
+	subscribers.Unlock()
+	for _, subscriber := range list {
+		subscriber(previous)
+	}
+	return true, nil
+}
+
+// Watch reload the configuration each time its file change, until stop is
+// called; dispatch is called from another goroutine and have to run reload,
+// like in the gui loop, the subscribers are called from there
+func Watch(dispatch func(reload func())) (stop func(), err error) {
+	if Filepath == "" {
+		return func() {}, nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create file watcher: %v", err)
+	}
+	// editors often replace the file, the directory is watched to see the new one
+	if err = watcher.Add(filepath.Dir(Filepath)); err != nil {
+		watcher.Close() // nolint: errcheck
+		return nil, fmt.Errorf("unable to watch %q: %v", filepath.Dir(Filepath), err)
+	}
+
+	reload := func() {
+		changed, err := Reload()
+		switch {
+		case err != nil:
+			log.Errorf("unable to reload configuration: %v", err)
+		case changed:
+			log.Infof("configuration reloaded from %q", Filepath)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case <-done:
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(Filepath) || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, func() { dispatch(reload) })
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warningf("configuration file watcher error: %v", err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			watcher.Close() // nolint: errcheck
+		})
+	}, nil
+}
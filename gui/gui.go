@@ -1,39 +1,47 @@
 package gui
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 
+	"github.com/gotk3/gotk3/glib"
 	"github.com/gotk3/gotk3/gtk"
 	"github.com/krostar/nebulo-golib/log"
 
 	"github.com/krostar/nebulo-client-desktop/api"
 	"github.com/krostar/nebulo-client-desktop/channel"
 	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/gui/task"
 	"github.com/krostar/nebulo-client-desktop/gui/view"
+	"github.com/krostar/nebulo-client-desktop/identity"
+	"github.com/krostar/nebulo-client-desktop/outbox"
+	"github.com/krostar/nebulo-client-desktop/ratchet"
+	"github.com/krostar/nebulo-client-desktop/user"
 )
 
 var baseTitle = "Nebulo - "
 var MainWindow *gtk.Window
 
+// stopOutbox stop the outbox delivery loop started on login
+var stopOutbox = func() {}
+
 // GUI start the main gui window
 func GUI() (err error) {
 	gtk.Init(nil)
+	view.OverrideDir = config.Current().Run.UIDir
 
-	var (
-		cert   = config.Config.Run.TLS.Cert
-		key    = config.Config.Run.TLS.Key
-		keypwd = config.Config.Run.TLS.KeyPassword
-	)
+	source := user.IdentitySource(&config.Current().Run.TLS)
 
-	// if cert is defined, try to login with it
-	if _, err = os.Stat(cert); err == nil {
-		if _, err = api.API.LoginWithCertsFilename(cert, key, []byte(keypwd)); err != nil {
-			err = fmt.Errorf("unable to log in using %q and %q: %v", cert, key, err)
+	// if the identity is defined, try to login with it
+	if _, errStat := os.Stat(source.Cert); source.Defined() && (errStat == nil || source.Store == identity.StorePKCS11) {
+		if _, err = api.API.LoginWithIdentity(context.Background(), source); err != nil {
+			err = fmt.Errorf("unable to log in using %q and %q: %v", source.Cert, source.Key, err)
 		}
 	} else {
-		err = errors.New("cert is undefined or missing")
+		err = errors.New("identity is undefined or missing")
 	}
 
 	if err != nil { // login failed, open the login/register view
@@ -49,25 +57,201 @@ func GUI() (err error) {
 		}
 	}
 
+	// reload the configuration from the gtk main loop, one reload at a time;
+	// the tasks read the configuration concurrently, it's guarded by locks
+	config.Subscribe(user.OnConfigReloaded)
+	stopWatch, errWatch := config.Watch(func(reload func()) {
+		if _, errIdle := glib.IdleAdd(func() bool {
+			reload()
+			return false
+		}); errIdle != nil {
+			log.Warningf("unable to schedule configuration reload: %v", errIdle)
+		}
+	})
+	if errWatch != nil {
+		log.Warningf("unable to watch configuration file: %v, changes need a restart", errWatch)
+		stopWatch = func() {}
+	}
+
 	// this block forever until main window is closed
 	gtk.Main()
+	stopWatch()
+	task.Stop()
+	stopOutbox()
+	identity.CloseTokens()
+	log.Infof("connections to the server: %s", api.API.Metrics())
+	if err = api.API.WriteTrace(); err != nil {
+		log.Errorf("unable to save the requests trace: %v", err)
+	}
 	return nil
 }
 
+// Alert display an error before the gui is started and wait for the user to close it
+func Alert(format string, args ...interface{}) {
+	gtk.Init(nil)
+	errorBox := gtk.MessageDialogNew(nil, gtk.DIALOG_MODAL, gtk.MESSAGE_ERROR, gtk.BUTTONS_CLOSE, format, args...)
+	errorBox.SetTitle(baseTitle + "Error")
+	errorBox.Run()
+	errorBox.Destroy()
+}
+
+// Setup ask for the server base url and its certification authority on
+// first run, the inputs are filled with the known values
+func Setup(baseURL string, caFile string) (_ string, _ string, err error) {
+	gtk.Init(nil)
+	view.OverrideDir = config.Current().Run.UIDir
+	saved := false
+	window := view.Setup{}
+	window.WindowBaseTitle = baseTitle
+	if err = window.Load(baseURL, caFile, func(url string, ca string) {
+		baseURL, caFile, saved = url, ca, true
+	}); err != nil {
+		return "", "", fmt.Errorf("unable to build setup window: %v", err)
+	}
+
+	// this block until the setup window is closed
+	gtk.Main()
+	if !saved {
+		return "", "", errors.New("setup cancelled")
+	}
+	return baseURL, caFile, nil
+}
+
 func onLoginSucceed() (err error) {
+	if err = outbox.Load(config.Current().Run.OutboxFile, user.Logged.KeyFingerprint); err != nil {
+		log.Warningf("unable to load outbox from %q: %v, unsent messages are lost", config.Current().Run.OutboxFile, err)
+	}
+
 	MainWindow := view.Main{}
 	MainWindow.WindowBaseTitle = baseTitle
+	MainWindow.OnLogout = func() { endSession(&MainWindow, nil) }
 	if err = MainWindow.Load(); err != nil {
 		return fmt.Errorf("unable to build main window: %v", err)
 	}
 
-	channel.Channels, err = api.API.ChannelList()
+	var channels map[string]*channel.Channel
+	task.Run(context.Background(), MainWindow.Spinner(), func(ctx context.Context) (err error) {
+		// loaded even without forward secrecy, peers may still use a published prekey
+		if err = ratchet.Load(config.Current().Run.RatchetFile); err != nil {
+			log.Warningf("unable to load ratchet state from %q: %v", config.Current().Run.RatchetFile, err)
+		} else if config.Current().Run.ForwardSecrecy {
+			if err = publishPrekey(ctx); err != nil {
+				log.Warningf("unable to publish prekey: %v, forward secrecy is inactive", err)
+			}
+		}
+
+		// keys of the channels are only known by the logged user
+		channel.ForgetKeys()
+		channels, err = api.API.ChannelList(ctx)
+		return err
+	}, func(err error) {
+		if err != nil {
+			MainWindow.ErrorDialog("Unable to fetch channels list", err)
+		} else {
+			channel.SetChannels(channels)
+		}
+		if err = MainWindow.ChannelsRefresh(); err != nil {
+			log.ErrorIf(fmt.Errorf("unable to reresh channel on GUI: %v", err)) // nolint: errcheck
+		}
+	})
+
+	// deliver the messages of the outbox in background, and refresh the view
+	// from the gtk main loop when their status change
+	stopOutbox = outbox.Start(sendOutboxMessage, func() {
+		if _, errIdle := glib.IdleAdd(func() bool {
+			log.ErrorIf(MainWindow.OutboxChanged()) // nolint: errcheck
+			return false
+		}); errIdle != nil {
+			log.Warningf("unable to schedule outbox refresh: %v", errIdle)
+		}
+	})
+	// display the server reachability, and deliver the pending messages as
+	// soon as the server is back
+	api.API.OnOnlineChange(func(online bool) {
+		if online {
+			outbox.RetryFailed()
+		}
+		if _, errIdle := glib.IdleAdd(func() bool {
+			MainWindow.SetOnline(online)
+			return false
+		}); errIdle != nil {
+			log.Warningf("unable to schedule online status refresh: %v", errIdle)
+		}
+	})
+	MainWindow.SetOnline(api.API.Online())
+	// the certificate may be revoked while the user is logged
+	api.API.OnSessionLost(func(reason error) {
+		if _, errIdle := glib.IdleAdd(func() bool {
+			endSession(&MainWindow, reason)
+			return false
+		}); errIdle != nil {
+			log.Warningf("unable to schedule session end: %v", errIdle)
+		}
+	})
+	return log.ErrorIf(MainWindow.OutboxChanged())
+}
+
+// endSession log the user out and open the login view in place of the main
+// view, with the same certificate and key selected; reason is the error
+// which ended the session, or nil when the user asked to log out
+func endSession(mainWindow *view.Main, reason error) {
+	source := user.IdentitySource(&config.Current().Run.TLS)
+
+	api.API.OnSessionLost(nil)
+	// the main window is about to be destroyed
+	api.API.OnOnlineChange(nil)
+	stopOutbox()
+	stopOutbox = func() {}
+	// executed once the pending api calls, which may still use the certificate, are over
+	task.RunExclusive(context.Background(), mainWindow.Spinner(), func(ctx context.Context) error {
+		return api.API.Logout()
+	}, func(err error) {
+		if err != nil {
+			log.Warningf("unable to reset tls options: %v", err)
+		}
+
+		window := view.Identity{}
+		window.WindowBaseTitle = baseTitle
+		if err = window.Load(onLoginSucceed); err != nil {
+			log.ErrorIf(fmt.Errorf("unable to build login window: %v", err)) // nolint: errcheck
+			gtk.MainQuit()
+			return
+		}
+		window.Prefill(source)
+		mainWindow.Close()
+
+		if reason != nil {
+			window.Dialog(gtk.MESSAGE_WARNING, "The server refused your identity, please log in again: %v", reason)
+		}
+	})
+}
+
+// publishPrekey publish the current prekey in the user profile if it
+// has been rotated or never signed since the last publication
+func publishPrekey(ctx context.Context) (err error) {
+	prekey, err := ratchet.CurrentPrekey()
 	if err != nil {
-		MainWindow.Dialog(gtk.MESSAGE_ERROR, "unable to fetch channels list: %v", err)
+		return err
 	}
-	err = MainWindow.ChannelsRefresh()
-	if err != nil {
-		return log.ErrorIf(fmt.Errorf("unable to reresh channel on GUI: %v", err))
+	if !bytes.Equal(prekey, user.Logged.Prekey) || len(user.Logged.PrekeySignature) == 0 {
+		signature, err := api.API.UserPrekeyUpdate(ctx, prekey)
+		if err != nil {
+			return err
+		}
+		user.Logged.Prekey, user.Logged.PrekeySignature = prekey, signature
 	}
 	return nil
 }
+
+// sendOutboxMessage deliver a message of the outbox as a task, so the logout
+// wait for it; the messages refused by the server are rejected, sending them
+// again would fail the same way
+func sendOutboxMessage(ctx context.Context, m outbox.Message) error {
+	return task.Do(ctx, func(ctx context.Context) error {
+		err := api.API.MessageCreateFromPayload(ctx, m.ChannelName, m.Payload)
+		if api.IsValidation(err) || api.IsForbidden(err) || api.IsNotFound(err) {
+			return fmt.Errorf("%w: %v", outbox.ErrRejected, err)
+		}
+		return err
+	})
+}
@@ -0,0 +1,186 @@
+package task
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/krostar/nebulo-golib/log"
+)
+
+// Work is the part of a task executed in background, it must not touch gtk widgets
+type Work func(ctx context.Context) error
+
+// Done is the part of a task executed from the gtk main loop once the work is over
+type Done func(err error)
+
+// maxRunning is the number of tasks executed at the same time
+const maxRunning = 4
+
+// job is a queued task
+type job struct {
+	lane      string // the jobs of a lane are executed one at a time, in order
+	exclusive bool   // executed alone, once the jobs queued before are over
+	run       func()
+}
+
+var (
+	// tasks waiting to be executed, in submission order; tasks are executed
+	// concurrently, except the ones sharing a lane like the messages sent to a
+	// channel, and the exclusive ones like the logout which must not run while
+	// an api call still use the certificate
+	pending    []*job
+	queueMutex sync.Mutex
+	running    int
+	busyLanes  = make(map[string]bool)
+	exclusive  bool
+
+	// number of running tasks using a spinner, only used from the gtk main loop
+	spinning = make(map[*gtk.Spinner]int)
+
+	// every task is cancelled when root is
+	root, cancelRoot = context.WithCancel(context.Background())
+)
+
+// Stop cancel every pending task, used when the gui is closed
+func Stop() {
+	cancelRoot()
+}
+
+// Run queue work to be executed in background and return immediately; done
+// is then called from the gtk main loop with the error returned by work, unless
+// the task has been cancelled. The spinner, when not nil, spins until every
+// task using it is over.
+func Run(parent context.Context, spinner *gtk.Spinner, work Work, done Done) (cancel context.CancelFunc) {
+	return submit(parent, spinner, &job{}, work, done)
+}
+
+// RunOrdered queue work like Run, it's executed once the tasks of the same
+// lane queued before are over
+func RunOrdered(parent context.Context, spinner *gtk.Spinner, lane string, work Work, done Done) (cancel context.CancelFunc) {
+	return submit(parent, spinner, &job{lane: lane}, work, done)
+}
+
+// RunExclusive queue work like Run, it's executed alone once every task
+// queued before is over, the tasks queued after wait for it
+func RunExclusive(parent context.Context, spinner *gtk.Spinner, work Work, done Done) (cancel context.CancelFunc) {
+	return submit(parent, spinner, &job{exclusive: true}, work, done)
+}
+
+func submit(parent context.Context, spinner *gtk.Spinner, j *job, work Work, done Done) (cancel context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-root.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	spinnerStart(spinner)
+
+	j.run = func() {
+		err := ctx.Err()
+		if err == nil {
+			err = work(ctx)
+		}
+		if _, errIdle := glib.IdleAdd(func() bool {
+			spinnerStop(spinner)
+			if ctx.Err() == nil {
+				done(err)
+			}
+			cancel()
+			return false
+		}); errIdle != nil {
+			log.Warningf("unable to schedule end of task: %v", errIdle)
+		}
+	}
+	enqueue(j)
+	return cancel
+}
+
+// Do queue work like Run and wait for its result, it's used by the
+// goroutines which aren't the gtk main loop; it must not be called from a task
+func Do(ctx context.Context, work Work) (err error) {
+	result := make(chan error, 1)
+	enqueue(&job{run: func() {
+		if err := ctx.Err(); err != nil {
+			result <- err
+			return
+		}
+		result <- work(ctx)
+	}})
+
+	select {
+	case err = <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enqueue add a job to the queue and start it if it can be
+func enqueue(j *job) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	pending = append(pending, j)
+	schedule()
+}
+
+// schedule start the pending jobs which can be executed right now,
+// queueMutex must be locked
+func schedule() {
+	for i := 0; i < len(pending) && !exclusive && running < maxRunning; {
+		j := pending[i]
+		switch {
+		case j.exclusive && (i > 0 || running > 0):
+			return // wait for the jobs queued before, and hold the next ones
+		case j.lane != "" && busyLanes[j.lane]:
+			i++
+			continue
+		}
+
+		pending = append(pending[:i], pending[i+1:]...)
+		running++
+		exclusive = j.exclusive
+		if j.lane != "" {
+			busyLanes[j.lane] = true
+		}
+		go execute(j)
+	}
+}
+
+// execute run a job and start the ones waiting for it
+func execute(j *job) {
+	j.run()
+
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	running--
+	exclusive = false
+	delete(busyLanes, j.lane)
+	schedule()
+}
+
+func spinnerStart(spinner *gtk.Spinner) {
+	if spinner == nil {
+		return
+	}
+	if spinning[spinner] == 0 {
+		spinner.Show()
+		spinner.Start()
+	}
+	spinning[spinner]++
+}
+
+func spinnerStop(spinner *gtk.Spinner) {
+	if spinner == nil {
+		return
+	}
+	spinning[spinner]--
+	if spinning[spinner] <= 0 {
+		delete(spinning, spinner)
+		spinner.Stop()
+		spinner.Hide()
+	}
+}
@@ -1,6 +1,7 @@
 package view
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/gotk3/gotk3/glib"
@@ -9,6 +10,8 @@ import (
 
 	"github.com/krostar/nebulo-client-desktop/api"
 	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/contact"
+	"github.com/krostar/nebulo-client-desktop/gui/task"
 	"github.com/krostar/nebulo-client-desktop/user"
 )
 
@@ -19,6 +22,10 @@ type ChannelAdd struct {
 	dialog    *gtk.Dialog
 	treeview  *gtk.TreeView
 	liststore *gtk.ListStore
+	spinner   *gtk.Spinner
+	contacts  []contact.Contact // listed in the treeview, in the same order
+
+	cancelCreate context.CancelFunc // cancel the pending channel creation, if any
 }
 
 // Load load and fill all the component of the add channel module
@@ -27,8 +34,8 @@ func (v *ChannelAdd) Load(parent *gtk.Window) (err error) {
 	if err != nil {
 		return fmt.Errorf("unable to create builder: %v", err)
 	}
-	// load the view from a file
-	if err = v.builder.AddFromFile("gui/view/channel_add.ui"); err != nil {
+	// load the view definition, embedded or overridden
+	if err = addDefinition(v.builder, "channel_add.ui"); err != nil {
 		return fmt.Errorf("unable to add file to builder: %v", err)
 	}
 
@@ -52,7 +59,15 @@ func (v *ChannelAdd) Load(parent *gtk.Window) (err error) {
 		return fmt.Errorf("unable to find treeview in builder: %v", err)
 	}
 
-	err = v.fillContacts(config.Config.Run.ContactsFile)
+	v.spinner, err = v.FindSpinnerWithBuilder(v.builder, "spinner_channel")
+	if err != nil {
+		return fmt.Errorf("unable to find spinner in builder: %v", err)
+	}
+	if _, err = v.dialog.Connect("destroy", v.onDestroy); err != nil {
+		return fmt.Errorf("unable to connect signal destroy to dialog: %v", err)
+	}
+
+	err = v.fillContacts(config.Current().Run.ContactsFile)
 	if err != nil {
 		return fmt.Errorf("unable to fill treeview with contacts: %v", err)
 	}
@@ -67,7 +82,8 @@ func (v *ChannelAdd) fillContacts(file string) (err error) {
 		return fmt.Errorf("unable to create list store: %v", err)
 	}
 
-	for _, contact := range user.Logged.Contacts {
+	v.contacts = user.Contacts()
+	for _, contact := range v.contacts {
 		iter := v.liststore.Append()
 		err = v.liststore.Set(iter, []int{0}, []interface{}{contact.Name})
 		if err != nil {
@@ -83,6 +99,13 @@ func (v *ChannelAdd) onCancelClicked() (err error) {
 	return nil
 }
 
+// onDestroy cancel the pending creation, its result would be shown in a destroyed dialog
+func (v *ChannelAdd) onDestroy() {
+	if v.cancelCreate != nil {
+		v.cancelCreate()
+	}
+}
+
 func (v *ChannelAdd) onAddClicked() (err error) {
 	entryChannelName, err := v.FindEntryWithBuilder(v.builder, "entry_channel_name")
 	if err != nil {
@@ -105,15 +128,29 @@ func (v *ChannelAdd) onAddClicked() (err error) {
 		if !ok {
 			return
 		}
-		channelMembersPkey = append(channelMembersPkey, user.Logged.Contacts[treepath.GetIndices()[0]].PublicKeyB64)
+		channelMembersPkey = append(channelMembersPkey, v.contacts[treepath.GetIndices()[0]].PublicKeyB64)
 	})
 
-	_, err = api.API.ChannelCreate(channelName, channelMembersPkey)
-	if err != nil {
-		v.Dialog(gtk.MESSAGE_ERROR, "An error occurred: %v", err)
+	v.setSensitive(false)
+	v.cancelCreate = task.Run(context.Background(), v.spinner, func(ctx context.Context) (err error) {
+		_, err = api.API.ChannelCreate(ctx, channelName, channelMembersPkey)
 		return err
-	}
-
-	v.dialog.Destroy()
+	}, func(err error) {
+		v.cancelCreate = nil
+		if err != nil {
+			v.setSensitive(true)
+			v.ErrorDialog("Unable to create channel", err)
+			return
+		}
+		v.dialog.Destroy()
+	})
 	return nil
 }
+
+// setSensitive allow or prevent a new creation while one is pending
+func (v *ChannelAdd) setSensitive(sensitive bool) {
+	if button, err := v.FindButtonWithBuilder(v.builder, "button_add"); err == nil {
+		button.SetSensitive(sensitive)
+	}
+	v.treeview.SetSensitive(sensitive)
+}
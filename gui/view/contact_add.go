@@ -23,8 +23,8 @@ func (v *ContactAdd) Load(parent *gtk.Window) (err error) {
 	if err != nil {
 		return fmt.Errorf("unable to create builder: %v", err)
 	}
-	// load the view from a file
-	if err = v.builder.AddFromFile("gui/view/contact_add.ui"); err != nil {
+	// load the view definition, embedded or overridden
+	if err = addDefinition(v.builder, "contact_add.ui"); err != nil {
 		return fmt.Errorf("unable to add file to builder: %v", err)
 	}
 
@@ -79,9 +79,9 @@ func (v *ContactAdd) onAddClicked() (err error) {
 	}
 
 	log.Debugf("new contact: %q, %q", contactName, contactPK)
-	_, err = contact.AddToFile(config.Config.Run.ContactsFile, contactName, contactPK)
+	_, err = contact.AddToFile(config.Current().Run.ContactsFile, contactName, contactPK)
 	if err != nil {
-		v.Dialog(gtk.MESSAGE_ERROR, "An error occurred: %v", err)
+		v.ErrorDialog("Unable to add contact", err)
 		return err
 	}
 
@@ -0,0 +1,53 @@
+package view
+
+import (
+	"embed"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/krostar/nebulo-golib/log"
+)
+
+// definitions are the glade files of the views, embedded in the binary
+//
+//go:embed *.ui
+var definitions embed.FS
+
+// OverrideDir is a directory whose .ui files are used instead of the
+// embedded ones, to theme or develop the views without rebuilding
+var OverrideDir string
+
+// addDefinition load the definition of a view in builder
+func addDefinition(builder *gtk.Builder, filename string) (err error) {
+	raw, err := readDefinition(filename)
+	if err != nil {
+		return err
+	}
+	if err = builder.AddFromString(string(raw)); err != nil {
+		return fmt.Errorf("unable to add definition %q to builder: %v", filename, err)
+	}
+	return nil
+}
+
+// readDefinition read a view definition from the override directory if it
+// has one, from the binary otherwise
+func readDefinition(filename string) (raw []byte, err error) {
+	if OverrideDir != "" {
+		path := filepath.Join(OverrideDir, filename)
+		raw, err = ioutil.ReadFile(path)
+		if err == nil {
+			log.Debugf("using view definition %q", path)
+			return raw, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("unable to read view definition %q: %v", path, err)
+		}
+	}
+	if raw, err = definitions.ReadFile(filename); err != nil {
+		return nil, fmt.Errorf("unable to read embedded view definition %q: %v", filename, err)
+	}
+	return raw, nil
+}
@@ -1,6 +1,7 @@
 package view
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -8,6 +9,9 @@ import (
 	"github.com/krostar/nebulo-golib/log"
 
 	"github.com/krostar/nebulo-client-desktop/api"
+	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/gui/task"
+	"github.com/krostar/nebulo-client-desktop/identity"
 )
 
 // Identity represent the login view
@@ -16,6 +20,9 @@ type Identity struct {
 	builder        *gtk.Builder
 	onLoginSucceed func() error
 	gtkQuitOnClose bool
+	spinner        *gtk.Spinner
+
+	cancelPending context.CancelFunc // cancel the pending login or registration, if any
 }
 
 // Load load and fill all the component of the login module
@@ -24,8 +31,8 @@ func (v *Identity) Load(onLoginSucceed func() error) (err error) {
 	if err != nil {
 		return fmt.Errorf("unable to create builder: %v", err)
 	}
-	// load the view from a file
-	if err = v.builder.AddFromFile("gui/view/identity.ui"); err != nil {
+	// load the view definition, embedded or overridden
+	if err = addDefinition(v.builder, "identity.ui"); err != nil {
 		return fmt.Errorf("unable to add file to builder: %v", err)
 	}
 
@@ -47,15 +54,71 @@ func (v *Identity) Load(onLoginSucceed func() error) (err error) {
 		return fmt.Errorf("unable to add button callback: %v", err)
 	}
 
+	for _, suffix := range []string{"login", "register"} {
+		combo, err := v.FindComboBoxTextWithBuilder(v.builder, "combo_store_"+suffix)
+		if err != nil {
+			return fmt.Errorf("unable to find identity store combo box: %v", err)
+		}
+		suffix := suffix
+		if _, err = combo.Connect("changed", func() { v.onStoreChanged(suffix) }); err != nil {
+			return fmt.Errorf("unable to attach changed signal to identity store combo box: %v", err)
+		}
+	}
+
+	v.spinner, err = v.FindSpinnerWithBuilder(v.builder, "spinner_identity")
+	if err != nil {
+		return fmt.Errorf("unable to find spinner in builder: %v", err)
+	}
+
+	// keys and certificates are kept in the data directory by default
+	for _, name := range []string{"filechooser_privkey_register", "filechooser_certificate_login", "filechooser_privkey_login"} {
+		if fileChooser, err := v.FindFileChooserButtonWithBuilder(v.builder, name); err == nil {
+			fileChooser.SetCurrentFolder(config.KeysDir())
+		}
+	}
+
 	v.gtkQuitOnClose = true
 
-	// finally show the window
+	// finally show the window, with the inputs of the default store
 	v.Window.ShowAll()
+	v.onStoreChanged("login")
+	v.onStoreChanged("register")
 	return nil
 }
 
+// Prefill select the identity of a previous login, only the
+// key password or the token PIN has to be typed again
+func (v *Identity) Prefill(source identity.Source) {
+	if combo, err := v.FindComboBoxTextWithBuilder(v.builder, "combo_store_login"); err == nil && source.Store != "" {
+		combo.SetActiveID(string(source.Store))
+	}
+	v.setFilename("filechooser_certificate_login", source.Cert)
+	if source.Store == identity.StorePKCS11 {
+		v.setFilename("filechooser_pkcs11_module_login", source.Module)
+		v.setText("entry_pkcs11_token_login", source.TokenLabel)
+		v.setText("entry_pkcs11_key_login", source.Key)
+	} else {
+		v.setFilename("filechooser_privkey_login", source.Key)
+	}
+}
+
+func (v *Identity) setFilename(fileChooserName string, filename string) {
+	if fileChooser, err := v.FindFileChooserButtonWithBuilder(v.builder, fileChooserName); err == nil && filename != "" {
+		fileChooser.SetFilename(filename)
+	}
+}
+
+func (v *Identity) setText(entryName string, text string) {
+	if entry, err := v.FindEntryWithBuilder(v.builder, entryName); err == nil && text != "" {
+		entry.SetText(text)
+	}
+}
+
 func (v *Identity) attachWindowBasicSignals() (err error) {
 	_, err = v.Window.Connect("destroy", func() error {
+		if v.cancelPending != nil {
+			v.cancelPending()
+		}
 		if v.gtkQuitOnClose {
 			gtk.MainQuit()
 		}
@@ -65,74 +128,158 @@ func (v *Identity) attachWindowBasicSignals() (err error) {
 }
 
 func (v *Identity) onLoginClicked() (err error) {
-	// get certificate from input
-	fileChooserCert, err := v.FindFileChooserButtonWithBuilder(v.builder, "filechooser_certificate_login")
+	source, err := v.loadSource("login")
 	if err != nil {
-		return log.ErrorIf(fmt.Errorf("unable to find file chooser certificate: %v", err))
+		return log.ErrorIf(fmt.Errorf("unable to load identity inputs: %v", err))
 	}
-	cert := fileChooserCert.GetFilename()
 
-	// get key and key password from inputs
-	key, keypwd, err := v.loadKeyInputs("login")
-	if err != nil {
-		return log.ErrorIf(fmt.Errorf("unable to load keys inputs: %v", err))
-	}
-
-	log.Debugf("selected identity key file: %q", key)
-	log.Debugf("selected crt to login: %q", cert)
+	log.Debugf("selected identity in %s store: key %q, crt %q", source.Store, source.Key, source.Cert)
 
 	// try to login
-	_, err = api.API.LoginWithCertsFilename(cert, key, []byte(keypwd))
-	if err != nil {
-		v.Dialog(gtk.MESSAGE_ERROR, "An error occurred: %v", err)
+	v.run(func(ctx context.Context) (err error) {
+		_, err = api.API.LoginWithIdentity(ctx, source)
 		return err
-	}
-
-	// it's a match! hide this window and let the magic happen
-	defer v.Window.Destroy()
-	v.gtkQuitOnClose = false
-	return log.ErrorIf(v.onLoginSucceed())
+	})
+	return nil
 }
 
 func (v *Identity) onRegisterClicked() (err error) {
-	// get key and key password from inputs
-	key, keypwd, err := v.loadKeyInputs("register")
+	source, err := v.loadSource("register")
 	if err != nil {
-		return log.ErrorIf(fmt.Errorf("unable to load keys inputs: %v", err))
+		return log.ErrorIf(fmt.Errorf("unable to load identity inputs: %v", err))
 	}
 
-	log.Debugf("selected identity key file: %q", key)
+	log.Debugf("selected identity in %s store: key %q", source.Store, source.Key)
 
 	// try to register
-	_, err = api.API.RegisterWithKeyPairFilename(key, []byte(keypwd))
-	if err != nil {
-		v.Dialog(gtk.MESSAGE_ERROR, "An error occurred: %v", err)
+	v.run(func(ctx context.Context) (err error) {
+		_, err = api.API.RegisterWithIdentity(ctx, source)
 		return err
+	})
+	return nil
+}
+
+// run execute a login or a registration in background, the buttons
+// are insensitive until it's over
+func (v *Identity) run(work task.Work) {
+	v.setButtonsSensitive(false)
+	v.cancelPending = task.Run(context.Background(), v.spinner, work, func(err error) {
+		v.cancelPending = nil
+		if err != nil {
+			v.setButtonsSensitive(true)
+			v.ErrorDialog("Unable to log in", err)
+			return
+		}
+
+		// it's a match! hide this window and let the magic happen
+		defer v.Window.Destroy()
+		v.gtkQuitOnClose = false
+		log.ErrorIf(v.onLoginSucceed()) // nolint: errcheck
+	})
+}
+
+func (v *Identity) setButtonsSensitive(sensitive bool) {
+	for _, name := range []string{"button_login", "button_register"} {
+		if button, err := v.FindButtonWithBuilder(v.builder, name); err == nil {
+			button.SetSensitive(sensitive)
+		}
 	}
+}
+
+// onStoreChanged display the inputs needed by the selected identity store
+func (v *Identity) onStoreChanged(suffix string) {
+	store := v.selectedStore(suffix)
+	v.setVisible(store == identity.StorePEM, "label_privkey_"+suffix, "filechooser_privkey_"+suffix)
+	v.setVisible(store == identity.StorePKCS11,
+		"label_pkcs11_module_"+suffix, "filechooser_pkcs11_module_"+suffix,
+		"label_pkcs11_token_"+suffix, "entry_pkcs11_token_"+suffix,
+		"label_pkcs11_key_"+suffix, "entry_pkcs11_key_"+suffix,
+	)
 
-	defer v.Window.Destroy()
-	v.gtkQuitOnClose = false
-	return log.ErrorIf(v.onLoginSucceed())
+	password, cert := "Private key password:", "Choose your nebulo certificate: "
+	switch store {
+	case identity.StorePKCS12:
+		password, cert = "Bundle password:", "Choose your PKCS#12 bundle: "
+	case identity.StorePKCS11:
+		password, cert = "Token PIN:", "Certificate, if not on the token: "
+	}
+	if label, err := v.FindLabelWithBuilder(v.builder, "label_privpwd_"+suffix); err == nil {
+		label.SetText(password)
+	}
+	if label, err := v.FindLabelWithBuilder(v.builder, "lbl_choose_cert_"+suffix); err == nil {
+		label.SetText(cert)
+	}
 }
 
-func (v *Identity) loadKeyInputs(suffix string) (key string, keypwd string, err error) {
-	fileChooserPrivKey, err := v.FindFileChooserButtonWithBuilder(v.builder, "filechooser_privkey_"+suffix)
-	if err != nil {
-		return "", "", fmt.Errorf("unable to find file chooser private key: %v", err)
+// setVisible show or hide widgets of the view
+func (v *Identity) setVisible(visible bool, names ...string) {
+	for _, name := range names {
+		if object, err := v.builder.GetObject(name); err == nil {
+			if widget, ok := object.(interface{ SetVisible(bool) }); ok {
+				widget.SetVisible(visible)
+			}
+		}
 	}
-	key = fileChooserPrivKey.GetFilename()
-	if key == "" {
-		return "", "", errors.New("no key file selected")
+}
+
+func (v *Identity) selectedStore(suffix string) identity.Store {
+	combo, err := v.FindComboBoxTextWithBuilder(v.builder, "combo_store_"+suffix)
+	if err != nil || combo.GetActiveID() == "" {
+		return identity.StorePEM
 	}
+	return identity.Store(combo.GetActiveID())
+}
+
+// loadSource return the identity described by the inputs
+func (v *Identity) loadSource(suffix string) (source identity.Source, err error) {
+	source.Store = v.selectedStore(suffix)
 
 	entryPrivKeyPwd, err := v.FindEntryWithBuilder(v.builder, "entry_privpwd_"+suffix)
 	if err != nil {
-		return "", "", fmt.Errorf("unable to find entry private key password: %v", err)
+		return source, fmt.Errorf("unable to find entry private key password: %v", err)
 	}
-	keypwd, err = entryPrivKeyPwd.GetText()
-	if err != nil {
-		return "", "", fmt.Errorf("unable to get text from entry private key password: %v", err)
+	if source.Password, err = entryPrivKeyPwd.GetText(); err != nil {
+		return source, fmt.Errorf("unable to get text from entry private key password: %v", err)
+	}
+
+	// only the login form have a certificate input
+	if fileChooserCert, errCert := v.FindFileChooserButtonWithBuilder(v.builder, "filechooser_certificate_"+suffix); errCert == nil {
+		source.Cert = fileChooserCert.GetFilename()
 	}
 
-	return key, keypwd, nil
+	switch source.Store {
+	case identity.StorePKCS12:
+		if source.Cert == "" {
+			return source, errors.New("no bundle file selected")
+		}
+	case identity.StorePKCS11:
+		fileChooserModule, err := v.FindFileChooserButtonWithBuilder(v.builder, "filechooser_pkcs11_module_"+suffix)
+		if err != nil {
+			return source, fmt.Errorf("unable to find file chooser module: %v", err)
+		}
+		if source.Module = fileChooserModule.GetFilename(); source.Module == "" {
+			return source, errors.New("no PKCS#11 module selected")
+		}
+		for name, value := range map[string]*string{"token": &source.TokenLabel, "key": &source.Key} {
+			entry, err := v.FindEntryWithBuilder(v.builder, "entry_pkcs11_"+name+"_"+suffix)
+			if err != nil {
+				return source, fmt.Errorf("unable to find entry %s label: %v", name, err)
+			}
+			if *value, err = entry.GetText(); err != nil {
+				return source, fmt.Errorf("unable to get text from entry %s label: %v", name, err)
+			}
+		}
+		if source.Key == "" {
+			return source, errors.New("no key label typed")
+		}
+	default:
+		fileChooserPrivKey, err := v.FindFileChooserButtonWithBuilder(v.builder, "filechooser_privkey_"+suffix)
+		if err != nil {
+			return source, fmt.Errorf("unable to find file chooser private key: %v", err)
+		}
+		if source.Key = fileChooserPrivKey.GetFilename(); source.Key == "" {
+			return source, errors.New("no key file selected")
+		}
+	}
+	return source, nil
 }
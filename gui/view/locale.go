@@ -0,0 +1,37 @@
+package view
+
+// #cgo pkg-config: glib-2.0
+// #include <stdlib.h>
+// #include <glib.h>
+import "C"
+
+import (
+	"time"
+	"unsafe"
+)
+
+// localized date formats, as understood by g_date_time_format
+const (
+	localeTime = "%X"    // the time, like 14:05:09 or 2:05:09 PM
+	localeDay  = "%A %x" // the weekday and the date, like Monday 10/19/26
+)
+
+// formatLocal format t in the local timezone with the strftime-like format,
+// following the locale set by gtk at initialization; fallback is a go layout
+// used if glib can't format the date
+func formatLocal(t time.Time, format string, fallback string) string {
+	dt := C.g_date_time_new_from_unix_local(C.gint64(t.Unix()))
+	if dt == nil {
+		return t.Local().Format(fallback)
+	}
+	defer C.g_date_time_unref(dt)
+
+	cformat := C.CString(format)
+	defer C.free(unsafe.Pointer(cformat))
+	formatted := C.g_date_time_format(dt, (*C.gchar)(cformat))
+	if formatted == nil {
+		return t.Local().Format(fallback)
+	}
+	defer C.g_free(C.gpointer(formatted))
+	return C.GoString((*C.char)(formatted))
+}
@@ -1,22 +1,25 @@
 package view
 
 import (
-	"crypto/rsa"
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gotk3/gotk3/gdk"
 	"github.com/gotk3/gotk3/glib"
 	"github.com/gotk3/gotk3/gtk"
 	"github.com/krostar/nebulo-golib/log"
-	"github.com/krostar/nebulo-golib/tools/cert"
-	"github.com/krostar/nebulo-golib/tools/crypto"
 
 	"github.com/krostar/nebulo-client-desktop/api"
+	"github.com/krostar/nebulo-client-desktop/attachment"
 	"github.com/krostar/nebulo-client-desktop/channel"
-	"github.com/krostar/nebulo-client-desktop/config"
+	"github.com/krostar/nebulo-client-desktop/gui/task"
 	"github.com/krostar/nebulo-client-desktop/message"
+	"github.com/krostar/nebulo-client-desktop/outbox"
 	"github.com/krostar/nebulo-client-desktop/user"
 )
 
@@ -26,9 +29,23 @@ type Main struct {
 	builder           *gtk.Builder
 	channelsTreeview  *gtk.TreeView
 	channelsListstore *gtk.ListStore
-	messagesTreeview  *gtk.TreeView
-	messagesListstore *gtk.ListStore
-	messageEntry      *gtk.Entry
+	messagesListbox   *gtk.ListBox
+	messageComposer   *gtk.TextView
+	attachButton      *gtk.Button
+	outboxLabel       *gtk.Label
+	spinner           *gtk.Spinner
+	gtkQuitOnClose    bool
+
+	// OnLogout is called when the user ask to log out
+	OnLogout func()
+
+	offline     bool               // whether the server is unreachable
+	channelName string             // the selected channel
+	messages    []*message.Message // the decrypted messages of the selected channel
+	rows        []*messageRow      // the displayed lines of the messages list
+	rowWidgets  []*gtk.ListBoxRow  // the widgets of rows, in the same order
+
+	cancelChannelLoad context.CancelFunc // cancel the loading of the previously selected channel, if any
 }
 
 // Load load and fill all the component of the main module
@@ -37,8 +54,8 @@ func (v *Main) Load() (err error) {
 	if err != nil {
 		return fmt.Errorf("unable to create builder: %v", err)
 	}
-	// load the view from a file
-	if err = v.builder.AddFromFile("gui/view/main.ui"); err != nil {
+	// load the view definition, embedded or overridden
+	if err = addDefinition(v.builder, "main.ui"); err != nil {
 		return fmt.Errorf("unable to add file to builder: %v", err)
 	}
 
@@ -64,49 +81,105 @@ func (v *Main) Load() (err error) {
 		return fmt.Errorf("unable to create channels list: %v", err)
 	}
 
-	v.messageEntry, err = v.FindEntryWithBuilder(v.builder, "entry_message")
+	v.messageComposer, err = v.FindTextViewWithBuilder(v.builder, "textview_message")
 	if err != nil {
-		return fmt.Errorf("unable to find button in builder: %v", err)
+		return fmt.Errorf("unable to find message composer in builder: %v", err)
 	}
-	if err = v.makeMessageEntryUneditable(); err != nil {
-		return fmt.Errorf("unable to make message entry uneditable: %v", err)
+	v.attachButton, err = v.FindButtonWithBuilder(v.builder, "button_attach")
+	if err != nil {
+		return fmt.Errorf("unable to find attach button in builder: %v", err)
+	}
+	if err = v.AttachButtonClickedSignal(v.builder, "button_attach", v.onAttachClicked); err != nil {
+		return fmt.Errorf("unable to attach signals: %v", err)
+	}
+	if err = v.attachDropSignals(); err != nil {
+		return fmt.Errorf("unable to attach drop signals: %v", err)
+	}
+	if err = v.makeMessageComposerUneditable(); err != nil {
+		return fmt.Errorf("unable to make message composer uneditable: %v", err)
 	}
-	if _, err = v.messageEntry.Connect("activate", v.onMessageSent, nil); err != nil {
-		return fmt.Errorf("unable to connect signal activate to message entry: %v", err)
+	if _, err = v.messageComposer.Connect("key-press-event", v.onMessageComposerKeyPressed); err != nil {
+		return fmt.Errorf("unable to connect signal key-press-event to message composer: %v", err)
+	}
+
+	v.outboxLabel, err = v.FindLabelWithBuilder(v.builder, "label_outbox")
+	if err != nil {
+		return fmt.Errorf("unable to find outbox label in builder: %v", err)
+	}
+	v.spinner, err = v.FindSpinnerWithBuilder(v.builder, "spinner_main")
+	if err != nil {
+		return fmt.Errorf("unable to find spinner in builder: %v", err)
 	}
 
+	v.gtkQuitOnClose = true
+	v.Authenticated = true
 	v.Window.ShowAll()
 	return nil
 }
 
-func (v *Main) makeMessageEntryUneditable() (err error) {
-	if err = v.messageEntry.SetProperty("editable", false); err != nil {
-		return fmt.Errorf("unable to make message entry uneditable")
+// Close close the main window without leaving the application,
+// another view is expected to be displayed
+func (v *Main) Close() {
+	v.gtkQuitOnClose = false
+	v.Window.Destroy()
+}
+
+// Spinner return the spinner of the status bar, used while tasks are running
+func (v *Main) Spinner() *gtk.Spinner {
+	return v.spinner
+}
+
+func (v *Main) makeMessageComposerUneditable() (err error) {
+	buffer, err := v.messageComposer.GetBuffer()
+	if err != nil {
+		return fmt.Errorf("unable to get message composer buffer: %v", err)
 	}
-	v.messageEntry.SetSensitive(false)
-	v.messageEntry.SetText("Pleace, select a channel")
+	v.messageComposer.SetEditable(false)
+	v.messageComposer.SetSensitive(false)
+	buffer.SetText("Pleace, select a channel")
+	v.attachButton.SetSensitive(false)
 	return nil
 }
 
-func (v *Main) makeMessageEntryEditable() (err error) {
-	if err = v.messageEntry.SetProperty("editable", true); err != nil {
-		return fmt.Errorf("unable to make message entry editable")
+func (v *Main) makeMessageComposerEditable() (err error) {
+	buffer, err := v.messageComposer.GetBuffer()
+	if err != nil {
+		return fmt.Errorf("unable to get message composer buffer: %v", err)
 	}
-	v.messageEntry.SetSensitive(true)
-	v.messageEntry.SetText("")
+	v.messageComposer.SetEditable(true)
+	v.messageComposer.SetSensitive(true)
+	buffer.SetText("")
+	v.attachButton.SetSensitive(api.API.Supports(api.CapabilityAttachments))
 	return nil
 }
 
+// onMessageComposerKeyPressed send the message on Enter, Shift+Enter insert a new line
+func (v *Main) onMessageComposerKeyPressed(_ *gtk.TextView, event *gdk.Event) bool {
+	keyEvent := &gdk.EventKey{Event: event}
+	if keyEvent.KeyVal() != gdk.KEY_Return && keyEvent.KeyVal() != gdk.KEY_KP_Enter {
+		return false
+	}
+	if gdk.ModifierType(keyEvent.State())&gdk.GDK_SHIFT_MASK != 0 {
+		return false // let the text view insert the new line
+	}
+	log.ErrorIf(v.onMessageSent()) // nolint: errcheck
+	return true
+}
+
 func (v *Main) onMessageSent() (err error) {
-	entryMessage, err := v.FindEntryWithBuilder(v.builder, "entry_message")
+	buffer, err := v.messageComposer.GetBuffer()
 	if err != nil {
-		return log.ErrorIf(fmt.Errorf("unable to find entry message: %v", err))
+		return log.ErrorIf(fmt.Errorf("unable to get message composer buffer: %v", err))
 	}
-	msg, err := entryMessage.GetText()
+	start, end := buffer.GetBounds()
+	msg, err := buffer.GetText(start, end, false)
 	if err != nil {
-		return log.ErrorIf(fmt.Errorf("unable to get text from entry message: %v", err))
+		return log.ErrorIf(fmt.Errorf("unable to get text from message composer: %v", err))
+	}
+	if strings.TrimSpace(msg) == "" {
+		return nil
 	}
-	entryMessage.SetText("")
+	buffer.SetText("")
 
 	selection, err := v.channelsTreeview.GetSelection()
 	if err != nil {
@@ -118,12 +191,182 @@ func (v *Main) onMessageSent() (err error) {
 	}
 
 	log.Debugf("Channel: %q -- Message: %q", channelName, msg)
-	if err = api.API.MessageCreate(channelName, msg); err != nil {
-		return log.ErrorIf(fmt.Errorf("unable to send message to server: %v", err))
+	content := &message.Content{Text: msg, Format: message.FormatPlain}
+	if containsMarkdown(msg) {
+		content.Format = message.FormatMarkdown
 	}
+	return v.sendContent(channelName, content)
+}
+
+// sendContent encrypt the message content in background and add it to the outbox,
+// after the messages previously sent to the channel
+func (v *Main) sendContent(channelName string, content *message.Content) (err error) {
+	task.RunOrdered(context.Background(), v.spinner, channelName, func(ctx context.Context) error {
+		return queueContent(ctx, channelName, content)
+	}, v.onContentQueued)
 	return nil
 }
 
+// onContentQueued refresh the view once a message has been added to the outbox
+func (v *Main) onContentQueued(err error) {
+	if err != nil {
+		v.ErrorDialog("Unable to send message", err)
+	}
+	log.ErrorIf(v.OutboxChanged()) // nolint: errcheck
+}
+
+// queueContent encrypt the message content and add it to the outbox, the
+// outbox delivery loop will send it; it's called outside of the gtk main loop
+func queueContent(ctx context.Context, channelName string, content *message.Content) (err error) {
+	plaintext, err := content.Plaintext()
+	if err != nil {
+		return fmt.Errorf("unable to encode message content: %v", err)
+	}
+	payload, err := api.API.MessageCreatePayload(ctx, channelName, plaintext)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt message: %w", err)
+	}
+	if _, err = outbox.Add(channelName, plaintext, payload); err != nil {
+		log.Warningf("unable to save outbox: %v", err)
+	}
+	return nil
+}
+
+func (v *Main) onAttachClicked() (err error) {
+	dialog, err := gtk.FileChooserDialogNewWith2Buttons("Attach a file", v.Window, gtk.FILE_CHOOSER_ACTION_OPEN,
+		"Cancel", gtk.RESPONSE_CANCEL, "Attach", gtk.RESPONSE_ACCEPT)
+	if err != nil {
+		return log.ErrorIf(fmt.Errorf("unable to create file chooser: %v", err))
+	}
+	defer dialog.Destroy()
+
+	if gtk.ResponseType(dialog.Run()) != gtk.RESPONSE_ACCEPT {
+		return nil
+	}
+	return v.sendAttachment(dialog.GetFilename())
+}
+
+// onFilesDropped send every file dropped on the messages list
+func (v *Main) onFilesDropped(_ *gtk.ListBox, _ *gdk.DragContext, _ int, _ int, data *gtk.SelectionData) (err error) {
+	if !api.API.Supports(api.CapabilityAttachments) {
+		v.Dialog(gtk.MESSAGE_WARNING, "The server doesn't support attachments")
+		return nil
+	}
+	for _, line := range strings.Split(string(data.GetData()), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		uri, err := url.Parse(line)
+		if err != nil || uri.Scheme != "file" {
+			log.Warningf("ignoring dropped uri %q: not a local file", line)
+			continue
+		}
+		if err = v.sendAttachment(uri.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendAttachment encrypt and upload a file, then send a message containing
+// the key to decrypt it to the channel members
+func (v *Main) sendAttachment(path string) (err error) {
+	if v.channelName == "" {
+		v.Dialog(gtk.MESSAGE_WARNING, "Please, select a channel first")
+		return nil
+	}
+
+	log.Debugf("Channel: %q -- Attachment: %q", v.channelName, path)
+	channelName := v.channelName
+	task.RunOrdered(context.Background(), v.spinner, channelName, func(ctx context.Context) error {
+		a, ciphertext, err := attachment.FromFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to attach file: %v", err)
+		}
+		if a.ID, err = api.API.FileUpload(ctx, ciphertext); err != nil {
+			return fmt.Errorf("unable to upload file: %w", err)
+		}
+		return queueContent(ctx, channelName, &message.Content{Attachment: a})
+	}, v.onContentQueued)
+	return nil
+}
+
+// saveAttachment ask where to save an attachment, download and decrypt it
+func (v *Main) saveAttachment(a *attachment.Attachment) (err error) {
+	dialog, err := gtk.FileChooserDialogNewWith2Buttons("Save attachment", v.Window, gtk.FILE_CHOOSER_ACTION_SAVE,
+		"Cancel", gtk.RESPONSE_CANCEL, "Save", gtk.RESPONSE_ACCEPT)
+	if err != nil {
+		return log.ErrorIf(fmt.Errorf("unable to create file chooser: %v", err))
+	}
+	defer dialog.Destroy()
+	dialog.SetCurrentName(a.Name)
+	dialog.SetDoOverwriteConfirmation(true)
+
+	if gtk.ResponseType(dialog.Run()) != gtk.RESPONSE_ACCEPT {
+		return nil
+	}
+	path := dialog.GetFilename()
+
+	task.Run(context.Background(), v.spinner, func(ctx context.Context) error {
+		ciphertext, err := api.API.FileDownload(ctx, a.ID)
+		if err != nil {
+			return fmt.Errorf("unable to download %q: %w", a.Name, err)
+		}
+		plaintext, err := a.Open(ciphertext)
+		if err != nil {
+			return fmt.Errorf("unable to decrypt %q: %v", a.Name, err)
+		}
+		if err = ioutil.WriteFile(path, plaintext, 0600); err != nil {
+			return fmt.Errorf("unable to save %q: %v", path, err)
+		}
+		return nil
+	}, func(err error) {
+		if err != nil {
+			v.ErrorDialog("Unable to save attachment", err)
+		}
+	})
+	return nil
+}
+
+// OutboxChanged refresh the displayed messages and the outbox status,
+// it is called when a message of the outbox changed
+func (v *Main) OutboxChanged() (err error) {
+	v.statusRefresh()
+	if err = v.messagesDisplay(); err != nil {
+		return log.ErrorIf(fmt.Errorf("unable to display messages: %v", err))
+	}
+	return nil
+}
+
+// SetOnline change the status of the view depending on the server reachability,
+// while offline messages stay in the outbox and the channels can't be loaded
+func (v *Main) SetOnline(online bool) {
+	v.offline = !online
+	v.statusRefresh()
+}
+
+// statusRefresh display the server reachability and the outbox status
+func (v *Main) statusRefresh() {
+	var status []string
+	if v.offline {
+		status = append(status, "Offline, waiting for the server to come back")
+	}
+	count, nextAttempt := outbox.Pending()
+	switch {
+	case count == 0:
+	case nextAttempt.IsZero():
+		status = append(status, fmt.Sprintf("%d message(s) waiting to be sent", count))
+	default:
+		status = append(status, fmt.Sprintf("%d message(s) waiting to be sent, next attempt at %s",
+			count, formatLocal(nextAttempt, localeTime, "15:04:05")))
+	}
+	if rejected := outbox.Rejected(); rejected > 0 {
+		status = append(status, fmt.Sprintf("%d message(s) refused by the server", rejected))
+	}
+	v.outboxLabel.SetText(strings.Join(status, " - "))
+}
+
 func (v *Main) onChannelSelectionChanged(selection *gtk.TreeSelection) (err error) {
 	channelName, err := v.getChannelFromSelectedChannelList(selection)
 	if err != nil {
@@ -134,28 +377,60 @@ func (v *Main) onChannelSelectionChanged(selection *gtk.TreeSelection) (err erro
 	if err != nil {
 		return log.ErrorIf(fmt.Errorf("unable to find channel description label: %v", err))
 	}
-	description.SetText(fmt.Sprintf("Select channel: %s", channelName))
-
-	messages, err := api.API.MessageList(channelName, time.Time{})
-	if err != nil {
-		v.Dialog(gtk.MESSAGE_ERROR, "An error occured on messages fetching: %v", err)
-		return log.ErrorIf(err)
-	}
-	if err = v.MessagesRefresh(messages); err != nil {
-		return log.ErrorIf(fmt.Errorf("unable to refresh messages: %v", err))
+	selected, _ := channel.Find(channelName)
+	description.SetText(fmt.Sprintf("Select channel: %s - %s", channelName, forwardSecrecyText(selected)))
+	v.channelName = channelName
+	v.messages = nil
+	if err = v.messagesDisplay(); err != nil {
+		return log.ErrorIf(fmt.Errorf("unable to clear messages: %v", err))
 	}
 
-	if err = v.makeMessageEntryEditable(); err != nil {
-		return log.ErrorIf(fmt.Errorf("unable to make message entry editable: %v", err))
+	// only the last selected channel matters
+	if v.cancelChannelLoad != nil {
+		v.cancelChannelLoad()
 	}
+	var messages []*message.Message
+	v.cancelChannelLoad = task.Run(context.Background(), v.spinner, func(ctx context.Context) (err error) {
+		if messages, err = api.API.MessageList(ctx, channelName, time.Time{}); err != nil {
+			return err
+		}
+		if err = api.API.MessagesDecrypt(ctx, channelName, messages); err != nil {
+			return fmt.Errorf("unable to decrypt: %w", err)
+		}
+		return nil
+	}, func(err error) {
+		v.cancelChannelLoad = nil
+		if err != nil {
+			v.ErrorDialog("Unable to fetch messages", err)
+			return
+		}
+		if err = v.MessagesRefresh(messages); err != nil {
+			log.ErrorIf(fmt.Errorf("unable to refresh messages: %v", err)) // nolint: errcheck
+			return
+		}
+		if err = v.makeMessageComposerEditable(); err != nil {
+			log.ErrorIf(fmt.Errorf("unable to make message composer editable: %v", err)) // nolint: errcheck
+		}
+	})
 	log.Debugf("new channel selected: %v", channelName)
 	return nil
 }
 
+// forwardSecrecyText describe whether the messages sent to a channel are protected by forward secrecy
+func forwardSecrecyText(c *channel.Channel) string {
+	if c == nil {
+		return "forward secrecy inactive"
+	}
+	if active, reason := api.ForwardSecrecy(c); !active {
+		return fmt.Sprintf("forward secrecy inactive (%s)", reason)
+	}
+	return "forward secrecy active, messages readable until the client is closed"
+}
+
 func (v *Main) ChannelsRefresh() (err error) {
 	v.channelsListstore.Clear()
 
-	for cName := range channel.Channels {
+	for _, cName := range channel.Names() {
 		iter := v.channelsListstore.Append()
 		err = v.channelsListstore.Set(iter, []int{0}, []interface{}{cName})
 		if err != nil {
@@ -165,31 +440,87 @@ func (v *Main) ChannelsRefresh() (err error) {
 	return nil
 }
 
+// MessagesRefresh display the decrypted messages of the selected channel
 func (v *Main) MessagesRefresh(messages []*message.Message) (err error) {
-	v.messagesListstore.Clear()
-	pKeyPem, err := cert.ParsePrivateKeyPEMFromFile(config.Config.Run.TLS.Key, []byte(config.Config.Run.TLS.KeyPassword))
-	if err != nil {
-		return fmt.Errorf("unable to decode PEM encoded private key file %q: %v", config.Config.Run.TLS.Key, err)
+	v.messages = messages
+
+	// the fetched messages contains the ones we sent
+	outbox.Prune(v.channelName)
+	return v.messagesDisplay()
+}
+
+// messagesDisplay fill the messages list with the selected channel messages
+// followed by the messages of the outbox
+func (v *Main) messagesDisplay() (err error) {
+	for _, widget := range v.rowWidgets {
+		widget.Destroy()
 	}
-	pKey, ok := pKeyPem.(*rsa.PrivateKey)
-	if !ok {
-		return errors.New("cant cast private key to rsa private key")
+	v.rows, v.rowWidgets = nil, nil
+
+	displayed := []*displayedMessage{}
+	for _, m := range v.messages {
+		displayed = append(displayed, &displayedMessage{Message: m})
 	}
+	pending := outbox.ByChannel(v.channelName)
+	for i := range pending {
+		pending[i].Plaintext = outboxPlaintext(pending[i])
+	}
+	displayed = append(displayed, displayedMessagesFromOutbox(pending)...)
 
-	for _, m := range messages {
-		log.Debugln(m.Ciphertext, m.Keys, m.Integrity)
-		plaintext, err := crypto.Decrypt(m.Ciphertext, m.Keys, m.Integrity, *pKey)
+	for _, row := range messageRowsFromMessages(displayed) {
+		widget, err := row.widget()
 		if err != nil {
-			return fmt.Errorf("unable to decrypt: %v", err)
+			return fmt.Errorf("unable to display message %q: %v", row.Body, err)
 		}
-		m.Plaintext = string(plaintext)
-		iter := v.messagesListstore.Append()
-		err = v.messagesListstore.Set(iter, []int{0}, []interface{}{
-			fmt.Sprintf("%s (%s): %s", m.Sender.KeyFingerprint, m.Sender.DisplayName, m.Plaintext),
-		})
-		if err != nil {
-			return fmt.Errorf("unable to insert channel %q (from %q): %v", m.Plaintext, m.Sender.DisplayName, err)
+		v.messagesListbox.Add(widget)
+		v.rows = append(v.rows, row)
+		v.rowWidgets = append(v.rowWidgets, widget)
+	}
+	return nil
+}
+
+// outboxPlaintext return the plaintext of an outbox message, messages
+// loaded from the outbox file are decrypted from the copy sent to ourself
+func outboxPlaintext(m outbox.Message) string {
+	if m.Plaintext != "" {
+		return m.Plaintext
+	}
+
+	plaintext, err := api.PayloadPlaintext(m.Payload)
+	if err != nil {
+		log.Warningf("unable to decrypt outbox message %q: %v", m.ID, err)
+		return ""
+	}
+
+	outbox.SetPlaintext(m.ID, plaintext)
+	return plaintext
+}
+
+// onMessageActivated retry a failed outbox message, remove a rejected
+// one or save an attachment
+func (v *Main) onMessageActivated(_ *gtk.ListBox, widget *gtk.ListBoxRow) (err error) {
+	index := widget.GetIndex()
+	if index < 0 || index >= len(v.rows) {
+		return nil
+	}
+	row := v.rows[index]
+
+	if m, ok := outbox.Find(row.OutboxID); ok && m.Status == outbox.StatusFailed {
+		if v.Confirm("This message has not been sent yet: %s\n\nRetry now?", m.Error) {
+			outbox.Retry(m.ID)
 		}
+		return nil
+	} else if ok && m.Status == outbox.StatusRejected {
+		if v.Confirm("The server refused this message: %s\n\nRemove it from the outbox?", m.Error) {
+			if err = outbox.Drop(m.ID); err != nil {
+				return log.ErrorIf(fmt.Errorf("unable to drop message: %v", err))
+			}
+			return v.OutboxChanged()
+		}
+		return nil
+	}
+	if row.Attachment != nil {
+		return v.saveAttachment(row.Attachment)
 	}
 	return nil
 }
@@ -199,15 +530,23 @@ func (v *Main) getChannelFromSelectedChannelList(selection *gtk.TreeSelection) (
 	if !ok {
 		return "", errors.New("ok is false on channel selection")
 	}
-	ivalue, err := model.(*gtk.TreeModel).GetValue(iter, 0)
+	channelName, err = getStringFromTreeModel(model.(*gtk.TreeModel), iter, 0)
 	if err != nil {
-		return "", fmt.Errorf("unable to get channel name value from tree model: %v", err)
+		return "", fmt.Errorf("unable to get channel name: %v", err)
 	}
-	channelName, err = ivalue.GetString()
+	return channelName, nil
+}
+
+func getStringFromTreeModel(model *gtk.TreeModel, iter *gtk.TreeIter, column int) (value string, err error) {
+	ivalue, err := model.GetValue(iter, column)
 	if err != nil {
-		return "", fmt.Errorf("unable to get channel name to string: %v", err)
+		return "", fmt.Errorf("unable to get value from tree model: %v", err)
 	}
-	return channelName, nil
+	value, err = ivalue.GetString()
+	if err != nil {
+		return "", fmt.Errorf("unable to get value to string: %v", err)
+	}
+	return value, nil
 }
 
 func (v *Main) createChannelList() (err error) {
@@ -233,20 +572,38 @@ func (v *Main) createChannelList() (err error) {
 }
 
 func (v *Main) createMessageList() (err error) {
-	v.messagesTreeview, err = v.FindTreeViewWithBuilder(v.builder, "treeview_messages")
+	v.messagesListbox, err = v.FindListBoxWithBuilder(v.builder, "listbox_messages")
 	if err != nil {
 		return fmt.Errorf("unable to find listbox message: %v", err)
 	}
-	v.messagesListstore, err = gtk.ListStoreNew(glib.TYPE_STRING)
+	if _, err = v.messagesListbox.Connect("row-activated", v.onMessageActivated); err != nil {
+		return fmt.Errorf("unable to attach row-activated signal to messages listbox: %v", err)
+	}
+	return nil
+}
+
+func (v *Main) attachDropSignals() (err error) {
+	target, err := gtk.TargetEntryNew("text/uri-list", gtk.TARGET_OTHER_APP, 0)
 	if err != nil {
-		return fmt.Errorf("unable to create list store: %v", err)
+		return fmt.Errorf("unable to create drop target: %v", err)
+	}
+	v.messagesListbox.DragDestSet(gtk.DEST_DEFAULT_ALL, []gtk.TargetEntry{*target}, gdk.ACTION_COPY)
+	if _, err = v.messagesListbox.Connect("drag-data-received", v.onFilesDropped); err != nil {
+		return fmt.Errorf("unable to attach drag-data-received signal to messages listbox: %v", err)
 	}
-	v.messagesTreeview.SetModel(v.messagesListstore)
 	return nil
 }
 
 func (v *Main) attachWindowBasicSignals() (err error) {
-	if _, err = v.Window.Connect("destroy", func() error { gtk.MainQuit(); return nil }, nil); err != nil {
+	if _, err = v.Window.Connect("destroy", func() error {
+		if v.cancelChannelLoad != nil {
+			v.cancelChannelLoad()
+		}
+		if v.gtkQuitOnClose {
+			gtk.MainQuit()
+		}
+		return nil
+	}, nil); err != nil {
 		return fmt.Errorf("unable to attach destroy signal to window: %v", err)
 	}
 
@@ -293,6 +650,19 @@ func (v *Main) attachMenuAppSignals() (err error) {
 	}, nil); err != nil {
 		return fmt.Errorf("unable to attach activate signal to app quit menuitem: %v", err)
 	}
+
+	appLogout, err := v.FindMenuItemWithBuilder(v.builder, "menuitem_app_logout")
+	if err != nil {
+		return fmt.Errorf("unable to find app logout menu item: %v", err)
+	}
+	if _, err = appLogout.Connect("activate", func() error {
+		if v.OnLogout != nil && v.Confirm("Log out from this identity?") {
+			v.OnLogout()
+		}
+		return nil
+	}, nil); err != nil {
+		return fmt.Errorf("unable to attach activate signal to app logout menuitem: %v", err)
+	}
 	return nil
 }
 
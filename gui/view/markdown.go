@@ -0,0 +1,133 @@
+package view
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+)
+
+// the supported markdown subset: **bold**, *italic* or _italic_,
+// `inline code` and ```code blocks```
+var (
+	markdownCodeBlockRegexp = regexp.MustCompile("(?s)```(?:[a-zA-Z0-9]*\\n)?(.*?)```")
+	markdownCodeRegexp      = regexp.MustCompile("`([^`\\n]+)`")
+	markdownBoldRegexp      = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	markdownItalicRegexp    = regexp.MustCompile(`\*([^*\n]+)\*|_([^_\n]+)_`)
+
+	markupTagRegexp = regexp.MustCompile(`<(/?)([a-z]+)[^>]*>`)
+)
+
+// containsMarkdown return whether the text use the supported markdown subset
+func containsMarkdown(text string) bool {
+	return markdownCodeBlockRegexp.MatchString(text) ||
+		markdownCodeRegexp.MatchString(text) ||
+		markdownBoldRegexp.MatchString(text) ||
+		markdownItalicMarkup(text) != text
+}
+
+// markdownMarkup render the supported markdown subset to pango markup
+func markdownMarkup(text string) string {
+	var (
+		markup bytes.Buffer
+		last   int
+	)
+	for _, loc := range markdownCodeBlockRegexp.FindAllStringSubmatchIndex(text, -1) {
+		markup.WriteString(markdownInlineMarkup(text[last:loc[0]]))
+		markup.WriteString(fmt.Sprintf(`<span font_family="monospace" background="#eeeeee">%s</span>`,
+			html.EscapeString(text[loc[2]:loc[3]])))
+		last = loc[1]
+	}
+	markup.WriteString(markdownInlineMarkup(text[last:]))
+	return balanceMarkup(markup.String())
+}
+
+// balanceMarkup nest the tags of markup properly, pango refuse overlapping
+// tags like in <i>a <b>b</i> c</b>: the tags opened inside a closed one
+// are closed with it and opened again after it
+func balanceMarkup(markup string) string {
+	type tag struct{ name, open string }
+	var (
+		balanced bytes.Buffer
+		opened   []tag
+		last     int
+	)
+	for _, loc := range markupTagRegexp.FindAllStringSubmatchIndex(markup, -1) {
+		balanced.WriteString(markup[last:loc[0]])
+		last = loc[1]
+		current := tag{name: markup[loc[4]:loc[5]], open: markup[loc[0]:loc[1]]}
+		if loc[3] == loc[2] { // opening tag
+			opened = append(opened, current)
+			balanced.WriteString(current.open)
+			continue
+		}
+
+		i := len(opened) - 1
+		for i >= 0 && opened[i].name != current.name {
+			i--
+		}
+		if i < 0 { // never opened, dropped
+			continue
+		}
+		for j := len(opened) - 1; j >= i; j-- {
+			balanced.WriteString("</" + opened[j].name + ">")
+		}
+		reopened := opened[i+1:]
+		for _, t := range reopened {
+			balanced.WriteString(t.open)
+		}
+		opened = append(opened[:i], reopened...)
+	}
+	balanced.WriteString(markup[last:])
+	for j := len(opened) - 1; j >= 0; j-- {
+		balanced.WriteString("</" + opened[j].name + ">")
+	}
+	return balanced.String()
+}
+
+// markdownInlineMarkup render inline code, then urls, bold and italic
+func markdownInlineMarkup(text string) string {
+	var (
+		markup bytes.Buffer
+		last   int
+	)
+	for _, loc := range markdownCodeRegexp.FindAllStringSubmatchIndex(text, -1) {
+		markup.WriteString(textMarkup(text[last:loc[0]], markdownEmphasisMarkup))
+		markup.WriteString(fmt.Sprintf(`<tt>%s</tt>`, html.EscapeString(text[loc[2]:loc[3]])))
+		last = loc[1]
+	}
+	markup.WriteString(textMarkup(text[last:], markdownEmphasisMarkup))
+	return markup.String()
+}
+
+// markdownEmphasisMarkup escape the text and render bold and italic markers,
+// escaping is done first as it never produce markers
+func markdownEmphasisMarkup(text string) string {
+	escaped := html.EscapeString(text)
+	escaped = markdownBoldRegexp.ReplaceAllString(escaped, "<b>$1</b>")
+	return markdownItalicMarkup(escaped)
+}
+
+// markdownItalicMarkup render italic markers which are not inside a word,
+// like in snake_case or 2*3*4
+func markdownItalicMarkup(text string) string {
+	var (
+		markup bytes.Buffer
+		last   int
+	)
+	for _, loc := range markdownItalicRegexp.FindAllStringSubmatchIndex(text, -1) {
+		if (loc[0] > 0 && isWordByte(text[loc[0]-1])) || (loc[1] < len(text) && isWordByte(text[loc[1]])) {
+			continue
+		}
+		inner := text[loc[0]+1 : loc[1]-1]
+		markup.WriteString(text[last:loc[0]])
+		markup.WriteString("<i>" + inner + "</i>")
+		last = loc[1]
+	}
+	markup.WriteString(text[last:])
+	return markup.String()
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b == '*' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
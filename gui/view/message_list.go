@@ -0,0 +1,242 @@
+package view
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"time"
+
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/gotk3/gotk3/pango"
+	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/attachment"
+	"github.com/krostar/nebulo-client-desktop/message"
+	"github.com/krostar/nebulo-client-desktop/outbox"
+	"github.com/krostar/nebulo-client-desktop/user"
+)
+
+// messages from the same sender posted within this duration are grouped
+const messageGroupingDelay = 5 * time.Minute
+
+var urlRegexp = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// displayedMessage is a message of the messages list, either
+// fetched from the server or waiting in the outbox
+type displayedMessage struct {
+	*message.Message
+	Outbox *outbox.Message
+}
+
+// messageRow is a line of the messages list, it is either
+// a message or a day separator
+type messageRow struct {
+	Sender   string // pango markup, empty when the message is grouped with the previous one
+	Posted   string
+	Body     string // pango markup, its urls are links opened when clicked
+	Status   string
+	OutboxID string
+
+	Attachment *attachment.Attachment // saved to disk when the row is activated
+}
+
+// widget create the line of the messages list displaying the row
+func (r *messageRow) widget() (row *gtk.ListBoxRow, err error) {
+	row, err = gtk.ListBoxRowNew()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create row: %v", err)
+	}
+	grid, err := gtk.GridNew()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create grid: %v", err)
+	}
+	grid.SetColumnSpacing(10)
+	row.Add(grid)
+
+	sender, err := messageLabel(r.Sender)
+	if err != nil {
+		return nil, err
+	}
+	sender.SetEllipsize(pango.ELLIPSIZE_END)
+	sender.SetMaxWidthChars(20)
+	grid.Attach(sender, 0, 0, 1, 1)
+
+	body, err := messageLabel(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	body.SetHExpand(true)
+	body.SetLineWrap(true)
+	body.SetLineWrapMode(pango.WRAP_WORD_CHAR)
+	body.SetSelectable(true)
+	if _, err = body.Connect("activate-link", onLinkActivated); err != nil {
+		return nil, fmt.Errorf("unable to attach activate-link signal to message: %v", err)
+	}
+	grid.Attach(body, 1, 0, 1, 1)
+
+	for column, text := range []string{r.Posted, r.Status} {
+		info, err := messageLabel(fmt.Sprintf(`<span foreground="#888888">%s</span>`, html.EscapeString(text)))
+		if err != nil {
+			return nil, err
+		}
+		grid.Attach(info, column+2, 0, 1, 1)
+	}
+	row.ShowAll()
+	return row, nil
+}
+
+// messageLabel create a label of a messages list line from pango markup
+func messageLabel(markup string) (label *gtk.Label, err error) {
+	label, err = gtk.LabelNew("")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create label: %v", err)
+	}
+	label.SetMarkup(markup)
+	label.SetXAlign(0)
+	label.SetYAlign(0)
+	return label, nil
+}
+
+// onLinkActivated log the url of a clicked link, gtk open it in the default
+// application as the signal isn't handled
+func onLinkActivated(_ *gtk.Label, uri string) bool {
+	log.Debugf("opening url %q", uri)
+	return false
+}
+
+// displayedMessagesFromOutbox convert the outbox messages to displayable
+// messages, sent by the logged user
+func displayedMessagesFromOutbox(pending []outbox.Message) (messages []*displayedMessage) {
+	for i := range pending {
+		m := &pending[i]
+		messages = append(messages, &displayedMessage{
+			Message: &message.Message{
+				Plaintext: m.Plaintext,
+				Sender:    *user.Logged,
+				Posted:    m.Created,
+			},
+			Outbox: m,
+		})
+	}
+	return messages
+}
+
+// messageRowsFromMessages create the list of rows to display based on messages,
+// messages are expected to be sorted by posted date
+func messageRowsFromMessages(messages []*displayedMessage) (rows []*messageRow) {
+	var previous *displayedMessage
+
+	for _, m := range messages {
+		posted := m.Posted.Local()
+
+		if previous == nil || !sameDay(previous.Posted.Local(), posted) {
+			rows = append(rows, daySeparatorRow(posted))
+			previous = nil
+		}
+
+		content := message.ParseContent(m.Plaintext)
+		row := &messageRow{
+			Posted:     formatLocal(posted, localeTime, "15:04:05"),
+			Body:       messageBodyMarkup(content),
+			Attachment: content.Attachment,
+		}
+		if content.Attachment != nil {
+			if row.Body != "" {
+				row.Body += "\n"
+			}
+			row.Body += attachmentMarkup(content.Attachment)
+		}
+		// only display the sender on the first message of a group
+		if previous == nil ||
+			previous.Sender.KeyFingerprint != m.Sender.KeyFingerprint ||
+			m.Posted.Sub(previous.Posted) > messageGroupingDelay {
+			row.Sender = messageSenderMarkup(m.Message)
+		}
+		if m.Outbox != nil {
+			row.Status = messageStatusText(m.Outbox)
+			row.OutboxID = m.Outbox.ID
+		}
+
+		rows = append(rows, row)
+		previous = m
+	}
+	return rows
+}
+
+func daySeparatorRow(day time.Time) *messageRow {
+	return &messageRow{
+		Body: fmt.Sprintf(`<span foreground="#888888" weight="bold">%s</span>`,
+			html.EscapeString(formatLocal(day, localeDay, "Monday 2 January 2006"))),
+	}
+}
+
+func messageSenderMarkup(m *message.Message) string {
+	name := m.Sender.DisplayName
+	if name == "" {
+		name = m.Sender.KeyFingerprint
+	}
+	return fmt.Sprintf("<b>%s</b>", html.EscapeString(name))
+}
+
+func attachmentMarkup(a *attachment.Attachment) string {
+	return fmt.Sprintf(`<span foreground="#2a76c6">&#128206; <b>%s</b> (%s, %s)</span>`,
+		html.EscapeString(a.Name), html.EscapeString(a.MIME), humanSize(a.Size))
+}
+
+// humanSize format a size in bytes in a readable way
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func messageStatusText(m *outbox.Message) string {
+	switch m.Status {
+	case outbox.StatusSending:
+		return "sending..."
+	case outbox.StatusFailed:
+		return fmt.Sprintf("failed, retry at %s", formatLocal(m.NextAttempt, localeTime, "15:04:05"))
+	case outbox.StatusRejected:
+		return "refused by the server"
+	default:
+		return string(m.Status)
+	}
+}
+
+// messageBodyMarkup render the message text depending on its format
+func messageBodyMarkup(content *message.Content) string {
+	if content.Format == message.FormatMarkdown {
+		return markdownMarkup(content.Text)
+	}
+	return textMarkup(content.Text, html.EscapeString)
+}
+
+// textMarkup render the urls of a text as links, the rest of the text is rendered by render
+func textMarkup(text string, render func(string) string) string {
+	var (
+		markup bytes.Buffer
+		last   int
+	)
+	for _, loc := range urlRegexp.FindAllStringIndex(text, -1) {
+		link := html.EscapeString(text[loc[0]:loc[1]])
+		markup.WriteString(render(text[last:loc[0]]))
+		markup.WriteString(fmt.Sprintf(`<a href="%s">%s</a>`, link, link))
+		last = loc[1]
+	}
+	markup.WriteString(render(text[last:]))
+	return markup.String()
+}
+
+func sameDay(a time.Time, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
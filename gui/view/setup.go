@@ -0,0 +1,92 @@
+package view
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/krostar/nebulo-golib/log"
+)
+
+// Setup represent the first run view, asking for the server to use
+type Setup struct {
+	Module
+	builder *gtk.Builder
+	onSaved func(baseURL string, caFile string)
+}
+
+// Load load and fill all the component of the setup module
+func (v *Setup) Load(baseURL string, caFile string, onSaved func(baseURL string, caFile string)) (err error) {
+	v.builder, err = gtk.BuilderNew()
+	if err != nil {
+		return fmt.Errorf("unable to create builder: %v", err)
+	}
+	// load the view definition, embedded or overridden
+	if err = addDefinition(v.builder, "setup.ui"); err != nil {
+		return fmt.Errorf("unable to add file to builder: %v", err)
+	}
+
+	v.onSaved = onSaved
+	// get window from loaded file
+	v.Window, err = v.FindWindowWithBuilder(v.builder, "window_setup")
+	if err != nil {
+		return fmt.Errorf("unable to find window in builder: %v", err)
+	}
+	v.Window.SetTitle(v.WindowBaseTitle + "Setup")
+
+	// the gtk loop only run the setup, closing the window end it
+	if _, err = v.Window.Connect("destroy", gtk.MainQuit); err != nil {
+		return fmt.Errorf("unable to attach signals: %v", err)
+	}
+	if err = v.AttachButtonClickedSignal(v.builder, "button_quit", v.onQuitClicked); err != nil {
+		return fmt.Errorf("unable to add button callback: %v", err)
+	}
+	if err = v.AttachButtonClickedSignal(v.builder, "button_save", v.onSaveClicked); err != nil {
+		return fmt.Errorf("unable to add button callback: %v", err)
+	}
+
+	if entry, err := v.FindEntryWithBuilder(v.builder, "entry_baseurl"); err == nil && baseURL != "" {
+		entry.SetText(baseURL)
+	}
+	if fileChooser, err := v.FindFileChooserButtonWithBuilder(v.builder, "filechooser_ca"); err == nil && caFile != "" {
+		fileChooser.SetFilename(caFile)
+	}
+
+	v.Window.ShowAll()
+	return nil
+}
+
+func (v *Setup) onQuitClicked() (err error) {
+	v.Window.Destroy()
+	return nil
+}
+
+func (v *Setup) onSaveClicked() (err error) {
+	entryBaseURL, err := v.FindEntryWithBuilder(v.builder, "entry_baseurl")
+	if err != nil {
+		return log.ErrorIf(fmt.Errorf("unable to find entry base url: %v", err))
+	}
+	baseURL, err := entryBaseURL.GetText()
+	if err != nil {
+		return log.ErrorIf(fmt.Errorf("unable to get text from entry base url: %v", err))
+	}
+	fileChooserCA, err := v.FindFileChooserButtonWithBuilder(v.builder, "filechooser_ca")
+	if err != nil {
+		return log.ErrorIf(fmt.Errorf("unable to find file chooser certification authority: %v", err))
+	}
+	caFile := fileChooserCA.GetFilename()
+
+	if u, errParse := url.Parse(baseURL); errParse != nil || u.Scheme != "https" || u.Host == "" {
+		v.ErrorDialog("Invalid server address", errors.New("the address must be an https:// url"))
+		return nil
+	}
+	if caFile == "" {
+		v.ErrorDialog("Missing certification authority", errors.New("select the certification authority of the server"))
+		return nil
+	}
+
+	v.onSaved(baseURL, caFile)
+	v.Window.Destroy()
+	return nil
+}
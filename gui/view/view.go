@@ -1,16 +1,26 @@
 package view
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/gotk3/gotk3/gtk"
 	"github.com/krostar/nebulo-golib/log"
+
+	"github.com/krostar/nebulo-client-desktop/api"
 )
 
 // Module represent a module of the application (login, chat, ...)
 type Module struct {
 	WindowBaseTitle string
 	Window          *gtk.Window
+
+	// Authenticated is set by the views requiring a logged user, the
+	// authentication failures end the session instead of being displayed
+	Authenticated bool
 }
 
 // OnClickEvent is the prototype of a button clicked event
@@ -34,6 +44,67 @@ func (m *Module) Dialog(messageType gtk.MessageType, format string, args ...inte
 	infoBox.Show()
 }
 
+// PinMismatchMessage warn the user the server certificate changed
+const PinMismatchMessage = "the server presented a public key which doesn't match the pinned ones, " +
+	"the connection may be intercepted and has been refused. " +
+	"If the server key has been rotated, update the pins in the configuration."
+
+// ErrorDialog display an error which occurred while doing action with a
+// message telling the user what to do about it
+func (m *Module) ErrorDialog(action string, err error) {
+	log.Errorf("%s: %v", action, err)
+	if m.Authenticated && api.IsUnauthorized(err) {
+		return
+	}
+
+	var message string
+	apiErr, isAPIErr := api.AsError(err)
+	switch {
+	case api.IsPinMismatch(err):
+		// the connection may be intercepted, the user has to notice it
+		errorBox := gtk.MessageDialogNew(m.Window, gtk.DIALOG_MODAL|gtk.DIALOG_DESTROY_WITH_PARENT, gtk.MESSAGE_ERROR, gtk.BUTTONS_CLOSE, "%s: %s", action, PinMismatchMessage)
+		errorBox.Run()
+		errorBox.Destroy()
+		return
+	case api.IsOffline(err):
+		message = "the server is unreachable, try again once it's back online"
+	case api.IsUnauthorized(err):
+		message = "the server refused your identity, please log in again"
+	case api.IsForbidden(err):
+		message = "you are not allowed to do this"
+	case api.IsNotFound(err):
+		message = "it doesn't exist on the server"
+	case api.IsValidation(err) && isAPIErr && len(apiErr.Fields()) > 0:
+		var fields []string
+		for field, reason := range apiErr.Fields() {
+			fields = append(fields, fmt.Sprintf("%s: %s", field, reason))
+		}
+		sort.Strings(fields)
+		message = "please fix the following: " + strings.Join(fields, ", ")
+	case errors.Is(err, api.ErrConflict):
+		message = "it already exists"
+	case errors.Is(err, context.DeadlineExceeded):
+		message = "the server took too long to answer, try again later"
+	case errors.Is(err, api.ErrServer):
+		message = "the server failed to handle the request, try again later"
+	default:
+		message = err.Error()
+	}
+
+	errorBox := gtk.MessageDialogNew(m.Window, gtk.DIALOG_DESTROY_WITH_PARENT, gtk.MESSAGE_ERROR, gtk.BUTTONS_CLOSE, "%s: %s", action, message)
+	if _, err = errorBox.Connect("response", errorBox.Destroy); err != nil {
+		log.Warningf("unable to connect response event: %v", err)
+	}
+	errorBox.Show()
+}
+
+// Confirm open a modal box asking a yes/no question and return the answer
+func (m *Module) Confirm(format string, args ...interface{}) bool {
+	questionBox := gtk.MessageDialogNew(m.Window, gtk.DIALOG_MODAL|gtk.DIALOG_DESTROY_WITH_PARENT, gtk.MESSAGE_QUESTION, gtk.BUTTONS_YES_NO, format, args...)
+	defer questionBox.Destroy()
+	return gtk.ResponseType(questionBox.Run()) == gtk.RESPONSE_YES
+}
+
 // AttachButtonClickedSignal attach the clicked sign to a button
 func (m *Module) AttachButtonClickedSignal(builder *gtk.Builder, buttonName string, onClick OnClickEvent) (err error) {
 	button, err := m.FindButtonWithBuilder(builder, buttonName)
@@ -176,6 +247,38 @@ func (m *Module) FindDialogWithBuilder(builder *gtk.Builder, dialogName string)
 	return dialog, nil
 }
 
+// FindSpinnerWithBuilder return a spinner stored in a builder, based on his name
+// nolint: dupl
+func (m *Module) FindSpinnerWithBuilder(builder *gtk.Builder, spinnerName string) (spinner *gtk.Spinner, err error) {
+	widget, err := builder.GetObject(spinnerName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get spinner %q from builder: %v", spinnerName, err)
+	}
+
+	spinner, ok := widget.(*gtk.Spinner)
+	if !ok {
+		return nil, fmt.Errorf("unable to cast spinner from widget")
+	}
+
+	return spinner, nil
+}
+
+// FindComboBoxTextWithBuilder return a combo box stored in a builder, based on his name
+// nolint: dupl
+func (m *Module) FindComboBoxTextWithBuilder(builder *gtk.Builder, comboName string) (combo *gtk.ComboBoxText, err error) {
+	widget, err := builder.GetObject(comboName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get combo box %q from builder: %v", comboName, err)
+	}
+
+	combo, ok := widget.(*gtk.ComboBoxText)
+	if !ok {
+		return nil, fmt.Errorf("unable to cast combo box from widget")
+	}
+
+	return combo, nil
+}
+
 // FindButtonWithBuilder return a button stored in a builder, based on his name
 // nolint: dupl
 func (m *Module) FindButtonWithBuilder(builder *gtk.Builder, buttonName string) (button *gtk.Button, err error) {
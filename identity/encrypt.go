@@ -0,0 +1,162 @@
+package identity
+
+import (
+	stdcrypto "crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+
+	"filippo.io/edwards25519"
+	"github.com/krostar/nebulo-golib/tools/crypto"
+
+	"github.com/krostar/nebulo-client-desktop/symmetric"
+)
+
+// ErrKeyNotExtractable is returned when decrypting with a key which never leave
+// its token and can't decrypt on the token, like elliptic curve keys
+var ErrKeyNotExtractable = errors.New("unable to decrypt with a key kept on a token")
+
+// hybridKeySize is the size of the AES and HMAC keys of the nebulo hybrid RSA scheme
+const hybridKeySize = 32
+
+// Encrypt encrypt plaintext for the owner of the key; RSA keys use the nebulo hybrid
+// scheme so existing clients can still decrypt, elliptic curve keys agree on a
+// symmetric key with an ephemeral key sent in keys, integrity is then unused
+func (k *PublicKey) Encrypt(plaintext []byte) (ciphertext []byte, keys []byte, integrity []byte, err error) {
+	var recipient *ecdh.PublicKey
+
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		return crypto.Crypt(plaintext, *key)
+	case *ecdsa.PublicKey:
+		if recipient, err = key.ECDH(); err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to convert ecdsa key: %v", err)
+		}
+	case ed25519.PublicKey:
+		if recipient, err = x25519PublicKey(key); err != nil {
+			return nil, nil, nil, err
+		}
+	default:
+		return nil, nil, nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to generate ephemeral key: %v", err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to agree on a key: %v", err)
+	}
+	keys = ephemeral.PublicKey().Bytes()
+	ciphertext, err = symmetric.Seal(agreedKey(shared, keys, recipient.Bytes()), plaintext, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ciphertext, keys, nil, nil
+}
+
+// Decrypt decrypt a ciphertext created by PublicKey.Encrypt
+func (k *PrivateKey) Decrypt(ciphertext []byte, keys []byte, integrity []byte) (plaintext []byte, err error) {
+	var private *ecdh.PrivateKey
+
+	switch key := k.signer.(type) {
+	case *rsa.PrivateKey:
+		return crypto.Decrypt(ciphertext, keys, integrity, *key)
+	case *ecdsa.PrivateKey:
+		if private, err = key.ECDH(); err != nil {
+			return nil, fmt.Errorf("unable to convert ecdsa key: %v", err)
+		}
+	case ed25519.PrivateKey:
+		if private, err = x25519PrivateKey(key); err != nil {
+			return nil, err
+		}
+	case stdcrypto.Decrypter:
+		// rsa keys of a token decrypt on it, elliptic curve schemes need the raw key
+		if k.Algorithm() == AlgorithmRSA {
+			return hybridDecrypt(key, ciphertext, keys, integrity)
+		}
+		return nil, fmt.Errorf("%w: %s key of type %T", ErrKeyNotExtractable, k.Algorithm(), key)
+	default:
+		// the elliptic curve schemes need the raw key, a token only sign with it
+		return nil, fmt.Errorf("%w: %s key of type %T", ErrKeyNotExtractable, k.Algorithm(), key)
+	}
+
+	ephemeral, err := private.Curve().NewPublicKey(keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %v", err)
+	}
+	shared, err := private.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("unable to agree on a key: %v", err)
+	}
+	return symmetric.Open(agreedKey(shared, keys, private.PublicKey().Bytes()), ciphertext, nil)
+}
+
+// hybridDecrypt decrypt a message of the nebulo hybrid RSA scheme with a
+// key which can't leave its token: the token unwrap keys, the RSA-OAEP
+// encrypted AES and HMAC keys, then integrity, the HMAC-SHA256 of the
+// ciphertext, is checked and the AES-CFB ciphertext, prefixed by its IV,
+// is decrypted locally
+func hybridDecrypt(key stdcrypto.Decrypter, ciphertext []byte, keys []byte, integrity []byte) (plaintext []byte, err error) {
+	unwrapped, err := key.Decrypt(rand.Reader, keys, &rsa.OAEPOptions{Hash: stdcrypto.SHA256})
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap message keys on the token: %v", err)
+	}
+	if len(unwrapped) != 2*hybridKeySize {
+		return nil, fmt.Errorf("unwrapped message keys are %d bytes long, expected %d", len(unwrapped), 2*hybridKeySize)
+	}
+	aesKey, hmacKey := unwrapped[:hybridKeySize], unwrapped[hybridKeySize:]
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(ciphertext) // nolint: errcheck
+	if !hmac.Equal(mac.Sum(nil), integrity) {
+		return nil, errors.New("message integrity check failed")
+	}
+
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher: %v", err)
+	}
+	if len(ciphertext) < aes.BlockSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	iv, ciphertext := ciphertext[:aes.BlockSize], ciphertext[aes.BlockSize:]
+	plaintext = make([]byte, len(ciphertext))
+	cipher.NewCFBDecrypter(block, iv).XORKeyStream(plaintext, ciphertext)
+	return plaintext, nil
+}
+
+// agreedKey derive the symmetric key from the shared secret and both public keys
+func agreedKey(shared []byte, ephemeral []byte, recipient []byte) []byte {
+	h := sha256.New()
+	h.Write(shared)    // nolint: errcheck
+	h.Write(ephemeral) // nolint: errcheck
+	h.Write(recipient) // nolint: errcheck
+	return h.Sum(nil)
+}
+
+// x25519PublicKey convert an Ed25519 public key to its X25519 equivalent
+func x25519PublicKey(key ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	point, err := new(edwards25519.Point).SetBytes(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ed25519 public key: %v", err)
+	}
+	return ecdh.X25519().NewPublicKey(point.BytesMontgomery())
+}
+
+// x25519PrivateKey convert an Ed25519 private key to its X25519 equivalent,
+// the scalar is the one Ed25519 derive from the seed
+func x25519PrivateKey(key ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	h := sha512.Sum512(key.Seed())
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
@@ -0,0 +1,61 @@
+package identity
+
+import (
+	"bytes"
+	stdcrypto "crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"testing"
+
+	"github.com/krostar/nebulo-golib/tools/crypto"
+)
+
+// tokenKey hide the type of a rsa key behind the interfaces of a key kept
+// on a token, which decrypt on the token but never leave it
+type tokenKey struct {
+	key *rsa.PrivateKey
+}
+
+func (k tokenKey) Public() stdcrypto.PublicKey {
+	return &k.key.PublicKey
+}
+
+func (k tokenKey) Sign(random io.Reader, digest []byte, opts stdcrypto.SignerOpts) ([]byte, error) {
+	return k.key.Sign(random, digest, opts)
+}
+
+func (k tokenKey) Decrypt(random io.Reader, msg []byte, opts stdcrypto.DecrypterOpts) ([]byte, error) {
+	return k.key.Decrypt(random, msg, opts)
+}
+
+// TestHybridDecryptInterop check a message encrypted by the nebulo hybrid
+// scheme of the other clients is decrypted with a key kept on a token
+func TestHybridDecryptInterop(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	pKey, err := NewPrivateKey(tokenKey{key: key})
+	if err != nil {
+		t.Fatalf("unable to wrap key: %v", err)
+	}
+
+	plaintext := []byte("hello from a token")
+	ciphertext, keys, integrity, err := crypto.Crypt(plaintext, key.PublicKey)
+	if err != nil {
+		t.Fatalf("unable to encrypt: %v", err)
+	}
+	decrypted, err := pKey.Decrypt(ciphertext, keys, integrity)
+	if err != nil {
+		t.Fatalf("unable to decrypt: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypted %q, expected %q", decrypted, plaintext)
+	}
+
+	integrity[0] ^= 0xff
+	if _, err = pKey.Decrypt(ciphertext, keys, integrity); err == nil {
+		t.Fatal("a tampered message has been decrypted")
+	}
+}
@@ -0,0 +1,198 @@
+package identity
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/krostar/nebulo-golib/tools/cert"
+)
+
+// Algorithm is the type of an identity key
+type Algorithm string
+
+const (
+	// AlgorithmRSA keys encrypt with the nebulo hybrid RSA scheme
+	AlgorithmRSA Algorithm = "rsa"
+	// AlgorithmECDSA keys are NIST P-256 keys, they encrypt with ECDH on the same curve
+	AlgorithmECDSA Algorithm = "ecdsa-p256"
+	// AlgorithmEd25519 keys encrypt with X25519, using the birationally equivalent curve
+	AlgorithmEd25519 Algorithm = "ed25519"
+)
+
+// PrivateKey is the private key of an identity, whatever its algorithm
+type PrivateKey struct {
+	signer crypto.Signer
+}
+
+// PublicKey is the public key of an identity, whatever its algorithm
+type PublicKey struct {
+	key crypto.PublicKey
+}
+
+// NewPrivateKey wrap a parsed private key, only RSA, ECDSA P-256 and Ed25519 keys
+// are supported; keys kept on a token are wrapped through their crypto.Signer
+func NewPrivateKey(key crypto.PrivateKey) (k *PrivateKey, err error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return &PrivateKey{signer: key}, nil
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ecdsa curve %s", key.Curve.Params().Name)
+		}
+		return &PrivateKey{signer: key}, nil
+	case ed25519.PrivateKey:
+		return &PrivateKey{signer: key}, nil
+	case *ed25519.PrivateKey:
+		return &PrivateKey{signer: *key}, nil
+	case crypto.Signer:
+		if _, err = NewPublicKey(key.Public()); err != nil {
+			return nil, err
+		}
+		return &PrivateKey{signer: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// NewPublicKey wrap a parsed public key, only RSA, ECDSA P-256 and Ed25519 keys are supported
+func NewPublicKey(key crypto.PublicKey) (k *PublicKey, err error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return &PublicKey{key: key}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ecdsa curve %s", key.Curve.Params().Name)
+		}
+		return &PublicKey{key: key}, nil
+	case ed25519.PublicKey:
+		return &PublicKey{key: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// LoadPrivateKey read a PEM encoded private key file, PKCS#8 is
+// tried when the key isn't in a format known by the cert package
+func LoadPrivateKey(filepath string, password []byte) (k *PrivateKey, err error) {
+	key, err := cert.ParsePrivateKeyPEMFromFile(filepath, password)
+	if err != nil {
+		raw, errRead := ioutil.ReadFile(filepath)
+		if errRead != nil {
+			return nil, fmt.Errorf("unable to read file %q: %v", filepath, errRead)
+		}
+		block, _ := pem.Decode(raw)
+		if block == nil || block.Type != "PRIVATE KEY" {
+			return nil, fmt.Errorf("unable to decode PEM encoded private key file %q: %v", filepath, err)
+		}
+		if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("unable to parse PKCS#8 private key file %q: %v", filepath, err)
+		}
+	}
+	return NewPrivateKey(key)
+}
+
+// ParsePublicKeyDERBase64 parse a base64 encoded PKIX public key, as found in user profiles
+func ParsePublicKeyDERBase64(b64 string) (k *PublicKey, err error) {
+	der, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode b64 pkey: %v", err)
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DER encoded public key: %v", err)
+	}
+	return NewPublicKey(key)
+}
+
+// Algorithm return the type of the key
+func (k *PrivateKey) Algorithm() Algorithm {
+	return k.Public().Algorithm()
+}
+
+// Public return the public part of the key
+func (k *PrivateKey) Public() *PublicKey {
+	return &PublicKey{key: k.signer.Public()}
+}
+
+// Signer return the key as a crypto.Signer, used to create certificate requests
+func (k *PrivateKey) Signer() crypto.Signer {
+	return k.signer
+}
+
+// SignatureAlgorithm return the x509 algorithm used to sign with the key
+func (k *PrivateKey) SignatureAlgorithm() x509.SignatureAlgorithm {
+	switch k.Algorithm() {
+	case AlgorithmECDSA:
+		return x509.ECDSAWithSHA256
+	case AlgorithmEd25519:
+		return x509.PureEd25519
+	default:
+		return x509.SHA512WithRSA
+	}
+}
+
+// Sign sign data with the hash matching the key algorithm
+func (k *PrivateKey) Sign(data []byte) (signature []byte, err error) {
+	switch k.Algorithm() {
+	case AlgorithmECDSA:
+		digest := sha256.Sum256(data)
+		return k.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case AlgorithmEd25519:
+		return k.signer.Sign(rand.Reader, data, crypto.Hash(0))
+	default:
+		digest := sha512.Sum512(data)
+		return k.signer.Sign(rand.Reader, digest[:], crypto.SHA512)
+	}
+}
+
+// Algorithm return the type of the key
+func (k *PublicKey) Algorithm() Algorithm {
+	switch k.key.(type) {
+	case *ecdsa.PublicKey:
+		return AlgorithmECDSA
+	case ed25519.PublicKey:
+		return AlgorithmEd25519
+	default:
+		return AlgorithmRSA
+	}
+}
+
+// Equal return whether both keys are the same
+func (k *PublicKey) Equal(other *PublicKey) bool {
+	key, ok := k.key.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(other.key)
+}
+
+// Verify check a signature created by PrivateKey.Sign
+func (k *PublicKey) Verify(data []byte, signature []byte) (err error) {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return errors.New("invalid ecdsa signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, signature) {
+			return errors.New("invalid ed25519 signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		digest := sha512.Sum512(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA512, digest[:], signature)
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+}
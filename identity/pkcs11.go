@@ -0,0 +1,84 @@
+package identity
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/krostar/nebulo-golib/log"
+)
+
+var (
+	// tokens store the opened tokens by module and label, a token session
+	// stay open for the life of the client since the key is used on each request
+	tokens      = make(map[string]*crypto11.Context)
+	tokensMutex sync.Mutex
+)
+
+// openToken log in the token, or return the already opened one
+func openToken(source Source) (token *crypto11.Context, err error) {
+	tokensMutex.Lock()
+	defer tokensMutex.Unlock()
+
+	id := source.Module + "\x00" + source.TokenLabel
+	if token, ok := tokens[id]; ok {
+		return token, nil
+	}
+	token, err = crypto11.Configure(&crypto11.Config{
+		Path:       source.Module,
+		TokenLabel: source.TokenLabel,
+		Pin:        source.Password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open token %q with module %q: %v", source.TokenLabel, source.Module, err)
+	}
+	tokens[id] = token
+	return token, nil
+}
+
+// loadPKCS11 find the key on the token, the certificate is read from the
+// certificate file when it exists, from the token otherwise
+func loadPKCS11(source Source) (id *Identity, err error) {
+	token, err := openToken(source)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := token.FindKeyPair(nil, []byte(source.Key))
+	if err != nil {
+		return nil, fmt.Errorf("unable to find key %q on token %q: %v", source.Key, source.TokenLabel, err)
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("no key %q on token %q", source.Key, source.TokenLabel)
+	}
+
+	id = &Identity{}
+	if id.Key, err = NewPrivateKey(signer); err != nil {
+		return nil, err
+	}
+	if id.Certificate, err = loadCertificateFile(source.Cert); err != nil {
+		return nil, err
+	}
+	if id.Certificate == nil {
+		if id.Certificate, err = token.FindCertificate(nil, []byte(source.Key), nil); err != nil {
+			return nil, fmt.Errorf("unable to find certificate %q on token %q: %v", source.Key, source.TokenLabel, err)
+		}
+	}
+	if err = matchKey(id.Certificate, id.Key); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// CloseTokens log out of every opened token, used when the client stop
+func CloseTokens() {
+	tokensMutex.Lock()
+	defer tokensMutex.Unlock()
+
+	for id, token := range tokens {
+		if err := token.Close(); err != nil {
+			log.Warningf("unable to close token: %v", err)
+		}
+		delete(tokens, id)
+	}
+}
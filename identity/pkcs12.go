@@ -0,0 +1,29 @@
+package identity
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// loadPKCS12 decode a .p12 bundle, it has to contain the certificate
+func loadPKCS12(source Source) (id *Identity, err error) {
+	raw, err := ioutil.ReadFile(source.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file %q: %v", source.Cert, err)
+	}
+	key, crt, chain, err := pkcs12.DecodeChain(raw, source.Password)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode PKCS#12 bundle %q: %v", source.Cert, err)
+	}
+
+	id = &Identity{Certificate: crt, Chain: chain}
+	if id.Key, err = NewPrivateKey(key); err != nil {
+		return nil, err
+	}
+	if err = matchKey(crt, id.Key); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
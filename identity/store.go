@@ -0,0 +1,137 @@
+package identity
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Store is where an identity key and its certificate are kept
+type Store string
+
+const (
+	// StorePEM identities are a PEM encoded certificate file and a PEM encoded key file
+	StorePEM Store = "pem"
+	// StorePKCS12 identities are a .p12 bundle containing the key and the certificate
+	StorePKCS12 Store = "pkcs12"
+	// StorePKCS11 identities have their key on a token, the certificate is
+	// either a PEM encoded file or on the token, with the key label
+	StorePKCS11 Store = "pkcs11"
+)
+
+// Source describe where to find an identity
+type Source struct {
+	Store    Store
+	Cert     string // certificate file, or the bundle for PKCS#12
+	Key      string // key file for PEM, key label for PKCS#11
+	Password string // key password, bundle password for PKCS#12, token PIN for PKCS#11
+
+	Module     string // PKCS#11 module, like /usr/lib/softhsm/libsofthsm2.so
+	TokenLabel string // PKCS#11 token label
+}
+
+// Defined return whether enough is known to try to load the identity
+func (s Source) Defined() bool {
+	switch s.Store {
+	case StorePKCS12:
+		return s.Cert != ""
+	case StorePKCS11:
+		return s.Module != "" && s.Key != ""
+	default:
+		return s.Cert != "" && s.Key != ""
+	}
+}
+
+// Identity is a key with its certificate, as used for the tls mutual authentication
+type Identity struct {
+	Key         *PrivateKey
+	Certificate *x509.Certificate   // nil before registration
+	Chain       []*x509.Certificate // intermediate certificates, if any
+}
+
+// Load load the key and the certificate described by the source, the
+// certificate may be missing as long as the key is found
+func Load(source Source) (id *Identity, err error) {
+	switch source.Store {
+	case "", StorePEM:
+		return loadPEM(source)
+	case StorePKCS12:
+		return loadPKCS12(source)
+	case StorePKCS11:
+		return loadPKCS11(source)
+	default:
+		return nil, fmt.Errorf("unknown identity store %q", source.Store)
+	}
+}
+
+// TLSCertificate return the identity as a certificate usable for tls,
+// the private key never leave its store
+func (id *Identity) TLSCertificate() (crt *tls.Certificate, err error) {
+	if id.Certificate == nil {
+		return nil, errors.New("identity has no certificate")
+	}
+	crt = &tls.Certificate{
+		Certificate: [][]byte{id.Certificate.Raw},
+		PrivateKey:  id.Key.Signer(),
+		Leaf:        id.Certificate,
+	}
+	for _, c := range id.Chain {
+		crt.Certificate = append(crt.Certificate, c.Raw)
+	}
+	return crt, nil
+}
+
+func loadPEM(source Source) (id *Identity, err error) {
+	id = &Identity{}
+	if id.Key, err = LoadPrivateKey(source.Key, []byte(source.Password)); err != nil {
+		return nil, err
+	}
+	if id.Certificate, err = loadCertificateFile(source.Cert); err != nil {
+		return nil, err
+	}
+	if err = matchKey(id.Certificate, id.Key); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// loadCertificateFile read a PEM encoded certificate, nil is returned when
+// the file doesn't exist yet
+func loadCertificateFile(filepath string) (crt *x509.Certificate, err error) {
+	if filepath == "" {
+		return nil, nil
+	}
+	raw, err := ioutil.ReadFile(filepath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read file %q: %v", filepath, err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("unable to decode PEM encoded certificate file %q", filepath)
+	}
+	if crt, err = x509.ParseCertificate(block.Bytes); err != nil {
+		return nil, fmt.Errorf("unable to parse certificate file %q: %v", filepath, err)
+	}
+	return crt, nil
+}
+
+// matchKey check the certificate has been issued for the key
+func matchKey(crt *x509.Certificate, key *PrivateKey) error {
+	if crt == nil {
+		return nil
+	}
+	public, err := NewPublicKey(crt.PublicKey)
+	if err != nil {
+		return fmt.Errorf("unsupported certificate key: %v", err)
+	}
+	if !public.Equal(key.Public()) {
+		return errors.New("the certificate doesn't match the private key")
+	}
+	return nil
+}
@@ -1,19 +1,37 @@
 package message
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 
+	"github.com/krostar/nebulo-client-desktop/attachment"
 	"github.com/krostar/nebulo-client-desktop/channel"
 	"github.com/krostar/nebulo-client-desktop/user"
 )
 
 type SecureMsg struct {
+	Algorithm string `json:"algorithm,omitempty"` // identity key algorithm of the receiver, rsa when empty
 	Message   []byte `json:"message"`
 	Keys      []byte `json:"keys"`
 	Integrity []byte `json:"integrity"`
 }
 
+const (
+	// VersionPerMember messages are encrypted for each member with its public key,
+	// messages without version use this scheme
+	VersionPerMember = 1
+	// VersionChannelKey messages are encrypted once with the channel key
+	VersionChannelKey = 2
+	// VersionRatchet messages are encrypted with a key of the sender ratchet chain
+	VersionRatchet = 3
+)
+
 type Message struct {
+	Version    int             `json:"version"`
+	KeyID      string          `json:"key_id"`
+	ChainID    string          `json:"chain_id"`
+	Index      uint32          `json:"index"`
 	Ciphertext []byte          `json:"message"`
 	Keys       []byte          `json:"keys"`
 	Integrity  []byte          `json:"integrity"`
@@ -22,3 +40,51 @@ type Message struct {
 	Sender     user.User       `json:"sender"`
 	Posted     time.Time       `json:"posted"`
 }
+
+// ContentVersion is the version of the content format produced by this client
+const ContentVersion = 1
+
+const (
+	// FormatPlain is used for text displayed as is
+	FormatPlain = "plain"
+	// FormatMarkdown is used for text containing bold, italic and code markers
+	FormatMarkdown = "markdown"
+)
+
+// Content is the plaintext of a message, a plain text is sent as is for
+// compatibility but formatted text or attachments are encoded in json
+type Content struct {
+	Version    int                    `json:"v"`
+	Format     string                 `json:"format,omitempty"`
+	Text       string                 `json:"text"`
+	Attachment *attachment.Attachment `json:"attachment,omitempty"`
+}
+
+// Plaintext encode the content to the plaintext to encrypt
+func (c *Content) Plaintext() (plaintext string, err error) {
+	if c.Attachment == nil && c.Format != FormatMarkdown {
+		return c.Text, nil
+	}
+	c.Version = ContentVersion
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal json: %v", err)
+	}
+	return string(raw), nil
+}
+
+// ParseContent decode a decrypted plaintext, anything which is not a content
+// is a plain text, and content from a newer version fall back to plain text
+func ParseContent(plaintext string) (c *Content) {
+	c = &Content{}
+	if err := json.Unmarshal([]byte(plaintext), c); err != nil || (c.Version == 0 && c.Attachment == nil) {
+		return &Content{Format: FormatPlain, Text: plaintext}
+	}
+	if c.Version > ContentVersion {
+		return &Content{Version: c.Version, Format: FormatPlain, Text: c.Text}
+	}
+	if c.Format != FormatMarkdown {
+		c.Format = FormatPlain
+	}
+	return c
+}
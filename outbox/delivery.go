@@ -0,0 +1,170 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/krostar/nebulo-golib/log"
+)
+
+const (
+	retryDelayMin = 2 * time.Second
+	retryDelayMax = 5 * time.Minute
+)
+
+// Sender deliver a message payload to the server
+type Sender func(ctx context.Context, m Message) error
+
+var wake = make(chan struct{}, 1)
+
+// Wake ask the delivery loop to look for messages to send
+func Wake() {
+	select {
+	case wake <- struct{}{}:
+	default: // the loop is already awaken
+	}
+}
+
+// Start run the delivery loop in background, onChange is called from the
+// loop goroutine after every status change; the returned function stop the
+// loop and cancel the delivery in progress
+func Start(send Sender, onChange func()) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			deliverDue(ctx, send, onChange)
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+			case <-time.After(untilNextAttempt()):
+			}
+		}
+	}()
+	return cancel
+}
+
+// deliverDue send every message which can be sent right now
+func deliverDue(ctx context.Context, send Sender, onChange func()) {
+	// don't send the messages dropped from another process
+	if err := Refresh(); err != nil {
+		log.Warningf("unable to refresh outbox: %v", err)
+	}
+	for ctx.Err() == nil {
+		m, ok := nextDue()
+		if !ok {
+			return
+		}
+		onChange()
+		err := send(ctx, m)
+		if err != nil {
+			log.Warningf("unable to deliver message %q of channel %q: %v", m.ID, m.ChannelName, err)
+		}
+		delivered(m.ID, err)
+		onChange()
+	}
+}
+
+// heads return the first unsent message of the logged user in each channel,
+// as messages of a channel are delivered in order only those can be sent;
+// the rejected messages are skipped, they will never be sent
+func heads() (list []*Message) {
+	seen := make(map[string]bool)
+	for _, m := range messages {
+		if m.Status == StatusSent || m.Status == StatusRejected || !owned(m) || seen[m.ChannelName] {
+			continue
+		}
+		seen[m.ChannelName] = true
+		list = append(list, m)
+	}
+	return list
+}
+
+// nextDue find a message to send and mark it as sending
+func nextDue() (m Message, ok bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	now := time.Now()
+	for _, head := range heads() {
+		if head.Status == StatusQueued || (head.Status == StatusFailed && !head.NextAttempt.After(now)) {
+			head.Status = StatusSending
+			head.Attempts++
+			return *head, true
+		}
+	}
+	return Message{}, false
+}
+
+// delivered update the message status with the delivery result
+func delivered(id string, err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	now := time.Now()
+	for _, m := range messages {
+		if m.ID != id {
+			continue
+		}
+		if errors.Is(err, ErrRejected) {
+			m.Status = StatusRejected
+			m.Error = err.Error()
+			m.NextAttempt = time.Time{}
+			break
+		} else if err != nil {
+			m.Status = StatusFailed
+			m.Error = err.Error()
+			m.NextAttempt = now.Add(retryDelay(m.Attempts))
+			break
+		}
+		m.Status = StatusSent
+		m.Error = ""
+		// the server is reachable again, no need to wait to retry the others
+		for _, other := range messages {
+			if other.Status == StatusFailed {
+				other.NextAttempt = now
+			}
+		}
+		break
+	}
+
+	if err = save(); err != nil {
+		log.Warningf("unable to save outbox: %v", err)
+	}
+}
+
+// retryDelay return the delay to wait after the nth failed attempt,
+// the delay grows exponentially and is jittered to spread the retries
+func retryDelay(attempts int) time.Duration {
+	ceiling := retryDelayMin
+	for i := 1; i < attempts && ceiling < retryDelayMax; i++ {
+		ceiling *= 2
+	}
+	if ceiling > retryDelayMax {
+		ceiling = retryDelayMax
+	}
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+}
+
+// untilNextAttempt return the time to wait before a failed message can be retried
+func untilNextAttempt() time.Duration {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	wait := retryDelayMax
+	now := time.Now()
+	for _, head := range heads() {
+		if head.Status != StatusFailed {
+			continue
+		}
+		if until := head.NextAttempt.Sub(now); until < wait {
+			wait = until
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package outbox
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lock take an exclusive lock on the outbox file, shared with the other
+// processes using it, and return the function releasing it
+func lock(filepath string) (unlock func(), err error) {
+	file, err := os.OpenFile(filepath+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open outbox lock file: %v", err)
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close() // nolint: errcheck
+		return nil, fmt.Errorf("unable to lock outbox file: %v", err)
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN) // nolint: errcheck
+		file.Close()                                   // nolint: errcheck
+	}, nil
+}
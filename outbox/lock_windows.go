@@ -0,0 +1,29 @@
+//go:build windows
+// +build windows
+
+package outbox
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lock take an exclusive lock on the outbox file, shared with the other
+// processes using it, and return the function releasing it
+func lock(filepath string) (unlock func(), err error) {
+	file, err := os.OpenFile(filepath+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open outbox lock file: %v", err)
+	}
+	overlapped := &windows.Overlapped{}
+	if err = windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, overlapped); err != nil {
+		file.Close() // nolint: errcheck
+		return nil, fmt.Errorf("unable to lock outbox file: %v", err)
+	}
+	return func() {
+		windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, overlapped) // nolint: errcheck
+		file.Close()                                                         // nolint: errcheck
+	}, nil
+}
@@ -0,0 +1,390 @@
+package outbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/krostar/nebulo-golib/log"
+)
+
+// Status is the sending state of a message of the outbox
+type Status string
+
+const (
+	// StatusQueued is used while the message wait for its turn to be sent
+	StatusQueued Status = "queued"
+	// StatusSending is used while the message is being sent to the server
+	StatusSending Status = "sending"
+	// StatusSent is used once the server acknowledged the message
+	StatusSent Status = "sent"
+	// StatusFailed is used when the last delivery attempt failed, it will be retried
+	StatusFailed Status = "failed"
+	// StatusRejected is used when the server refused the message, it will
+	// never be sent and don't block the next messages of its channel
+	StatusRejected Status = "rejected"
+)
+
+// ErrRejected is wrapped by the senders errors when retrying won't help,
+// like when the channel doesn't exist or the message is invalid
+var ErrRejected = errors.New("refused by the server")
+
+// Message is a message written by the logged user, displayed
+// locally and kept on disk until the server acknowledged it
+type Message struct {
+	ID          string          `json:"id"`
+	ChannelName string          `json:"channel_name"`
+	Sender      string          `json:"sender"`  // key fingerprint of the identity which encrypted the message
+	Payload     json.RawMessage `json:"payload"` // encrypted request body, ready to be sent
+	Plaintext   string          `json:"-"`       // never written to disk
+	Created     time.Time       `json:"created"`
+	Status      Status          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	Error       string          `json:"error,omitempty"`
+}
+
+var (
+	// Filepath is the path of the file where unsent messages are saved
+	Filepath string
+	// owner is the key fingerprint of the logged user, only its messages
+	// are listed and delivered; every message is listed when empty
+	owner string
+
+	// messages of the outbox, in creation order
+	messages []*Message
+	mutex    sync.Mutex
+
+	// persisted are the ids of the messages in the outbox file when this
+	// process last read or wrote it, to tell the messages dropped by
+	// another process, like the outbox command, from the unsaved ones
+	persisted = map[string]bool{}
+)
+
+// Load fill the outbox with the unsent messages saved in filepath, the
+// messages of other identities than sender are kept in the file but are
+// never sent, they were encrypted and signed by another identity; every
+// message is listed when sender is empty
+func Load(filepath string, sender string) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	Filepath = filepath
+	owner = sender
+	messages = []*Message{}
+	persisted = map[string]bool{}
+	if filepath == "" {
+		return nil
+	}
+
+	unlock, err := lock(filepath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if messages, err = readFile(); err != nil {
+		return err
+	}
+	others := 0
+	for _, m := range messages {
+		persisted[m.ID] = true
+		if !owned(m) {
+			others++
+		}
+		// messages still sending have been interrupted by the client shutdown
+		if m.Status == StatusSending {
+			m.Status = StatusFailed
+			m.Error = "interrupted"
+		}
+	}
+	if others > 0 {
+		log.Warningf("%d message(s) of the outbox have been written by another identity, they are only sent when it's logged", others)
+	}
+	return nil
+}
+
+// owned return whether a message has been written by the logged user
+func owned(m *Message) bool {
+	return owner == "" || m.Sender == owner
+}
+
+// readFile read the messages saved in the outbox file
+func readFile() (saved []*Message, err error) {
+	raw, err := ioutil.ReadFile(Filepath)
+	if os.IsNotExist(err) {
+		return []*Message{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read file %q: %v", Filepath, err)
+	}
+
+	if err = json.Unmarshal(raw, &saved); err != nil {
+		return nil, fmt.Errorf("unable to parse json file: %v", err)
+	}
+	return saved, nil
+}
+
+// refresh apply the changes made to the outbox file by another process,
+// the outbox file must be locked
+func refresh() (err error) {
+	saved, err := readFile()
+	if err != nil {
+		return err
+	}
+
+	onDisk := make(map[string]bool, len(saved))
+	for _, m := range saved {
+		onDisk[m.ID] = true
+	}
+	inMemory := make(map[string]bool, len(messages))
+	kept := []*Message{}
+	for _, m := range messages {
+		inMemory[m.ID] = true
+		// saved before but no longer in the file, it has been dropped
+		if persisted[m.ID] && !onDisk[m.ID] {
+			continue
+		}
+		kept = append(kept, m)
+	}
+	for _, m := range saved {
+		// never seen, it has been added by another process
+		if !inMemory[m.ID] && !persisted[m.ID] {
+			kept = append(kept, m)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Created.Before(kept[j].Created) })
+
+	messages = kept
+	persisted = onDisk
+	return nil
+}
+
+// Refresh apply the changes made to the outbox file by another process
+func Refresh() (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if Filepath == "" {
+		return nil
+	}
+
+	unlock, err := lock(Filepath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return refresh()
+}
+
+// Save write the unsent messages to the outbox file
+func Save() (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return save()
+}
+
+func save() (err error) {
+	if Filepath == "" {
+		return nil
+	}
+
+	unlock, err := lock(Filepath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// don't resurrect the messages dropped since the last save
+	if err = refresh(); err != nil {
+		return err
+	}
+
+	unsent := []*Message{}
+	for _, m := range messages {
+		if m.Status != StatusSent {
+			unsent = append(unsent, m)
+		}
+	}
+
+	raw, err := json.MarshalIndent(unsent, "", "    ")
+	if err != nil {
+		return fmt.Errorf("unable to create json: %v", err)
+	}
+	if err = ioutil.WriteFile(Filepath, raw, 0600); err != nil {
+		return fmt.Errorf("unable to write outbox file %q: %v", Filepath, err)
+	}
+
+	persisted = make(map[string]bool, len(unsent))
+	for _, m := range unsent {
+		persisted[m.ID] = true
+	}
+	return nil
+}
+
+// Add queue a new message, the message is returned
+// even if the outbox can't be saved
+func Add(channelName string, plaintext string, payload []byte) (m Message, err error) {
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return m, fmt.Errorf("unable to generate message id: %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	added := &Message{
+		ID:          hex.EncodeToString(id),
+		ChannelName: channelName,
+		Sender:      owner,
+		Payload:     payload,
+		Plaintext:   plaintext,
+		Created:     time.Now(),
+		Status:      StatusQueued,
+	}
+	messages = append(messages, added)
+	Wake()
+	return *added, save()
+}
+
+// Drop remove a message from the outbox, it will never be sent
+func Drop(id string) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for i, m := range messages {
+		if m.ID == id {
+			messages = append(messages[:i], messages[i+1:]...)
+			return save()
+		}
+	}
+	return fmt.Errorf("message %q not found in outbox", id)
+}
+
+// Find return a copy of the message with the provided id
+func Find(id string) (m Message, ok bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, m := range messages {
+		if m.ID == id {
+			return *m, true
+		}
+	}
+	return Message{}, false
+}
+
+// List return a copy of the messages of the outbox written by the logged user
+func List() (list []Message) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, m := range messages {
+		if owned(m) {
+			list = append(list, *m)
+		}
+	}
+	return list
+}
+
+// ByChannel return a copy of the messages of the outbox posted in a channel
+// by the logged user
+func ByChannel(channelName string) (list []Message) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, m := range messages {
+		if m.ChannelName == channelName && owned(m) {
+			list = append(list, *m)
+		}
+	}
+	return list
+}
+
+// Pending return the number of messages of the logged user waiting to be
+// acknowledged by the server and the date of the next delivery attempt
+func Pending() (count int, nextAttempt time.Time) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, m := range messages {
+		if m.Status == StatusSent || m.Status == StatusRejected || !owned(m) {
+			continue
+		}
+		count++
+		if m.Status == StatusFailed && (nextAttempt.IsZero() || m.NextAttempt.Before(nextAttempt)) {
+			nextAttempt = m.NextAttempt
+		}
+	}
+	return count, nextAttempt
+}
+
+// Rejected return the number of messages of the logged user refused by the server
+func Rejected() (count int) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, m := range messages {
+		if m.Status == StatusRejected && owned(m) {
+			count++
+		}
+	}
+	return count
+}
+
+// SetPlaintext store the plaintext of a message loaded from the outbox file
+func SetPlaintext(id string, plaintext string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, m := range messages {
+		if m.ID == id {
+			m.Plaintext = plaintext
+		}
+	}
+}
+
+// Retry schedule the immediate delivery of a failed message
+func Retry(id string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, m := range messages {
+		if m.ID == id && m.Status == StatusFailed {
+			m.NextAttempt = time.Now()
+		}
+	}
+	Wake()
+}
+
+// RetryFailed schedule the immediate delivery of every failed message
+func RetryFailed() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, m := range messages {
+		if m.Status == StatusFailed {
+			m.NextAttempt = time.Now()
+		}
+	}
+	Wake()
+}
+
+// Prune remove the sent messages of a channel, used once
+// the server messages list of the channel has been fetched
+func Prune(channelName string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	kept := []*Message{}
+	for _, m := range messages {
+		if m.ChannelName != channelName || m.Status != StatusSent {
+			kept = append(kept, m)
+		}
+	}
+	messages = kept
+}
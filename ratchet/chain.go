@@ -0,0 +1,239 @@
+package ratchet
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/krostar/nebulo-client-desktop/symmetric"
+)
+
+const (
+	// ChainMaxMessages is the number of messages after which a new sending chain is created
+	ChainMaxMessages = 100
+	// ChainLifetime is the duration after which a new sending chain is created
+	ChainLifetime = 24 * time.Hour
+	// maxSkipped is the maximum number of message keys kept for messages not received yet
+	maxSkipped = 1000
+)
+
+// ErrConsumed is returned when the key of a message has already been used
+// and destroyed, the message can't be decrypted anymore
+var ErrConsumed = errors.New("message key has been destroyed after use")
+
+// Chain is a symmetric ratchet, each message is encrypted with a key derived
+// from the chain key which is then replaced by a new one derived from itself;
+// as previous chain keys are destroyed, a leaked state don't expose past messages
+type Chain struct {
+	ID          string            `json:"id"`
+	ChannelName string            `json:"channel_name"`
+	Sender      string            `json:"sender"`            // fingerprint of the user encrypting with the chain
+	Members     []string          `json:"members,omitempty"` // sorted fingerprints the chain has been distributed to
+	Key         []byte            `json:"key"`               // chain key of the next message
+	Index       uint32            `json:"index"`             // index of the next message
+	Skipped     map[uint32][]byte `json:"skipped,omitempty"` // keys of messages not received yet
+	Created     time.Time         `json:"created"`
+}
+
+// kdf derive a key from key for a specific purpose
+func kdf(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose)) // nolint: errcheck
+	return mac.Sum(nil)
+}
+
+// step return the key of the current message and move the chain forward
+func (c *Chain) step() (messageKey []byte) {
+	messageKey = kdf(c.Key, "message")
+	c.Key = kdf(c.Key, "chain")
+	c.Index++
+	return messageKey
+}
+
+// distributedTo return whether the chain has been distributed to exactly these members
+func (c *Chain) distributedTo(members []string) bool {
+	sorted := append([]string{}, members...)
+	sort.Strings(sorted)
+	if len(sorted) != len(c.Members) {
+		return false
+	}
+	for i := range sorted {
+		if sorted[i] != c.Members[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// NewSendingChain create the chain used by sender to encrypt the next messages of
+// a channel, the returned seed has to be wrapped for every member with Wrap
+func NewSendingChain(channelName string, sender string, members []string) (c *Chain, seed []byte, err error) {
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return nil, nil, fmt.Errorf("unable to generate chain id: %v", err)
+	}
+	if seed, err = symmetric.NewKey(); err != nil {
+		return nil, nil, err
+	}
+	sorted := append([]string{}, members...)
+	sort.Strings(sorted)
+
+	return &Chain{
+		ID:          hex.EncodeToString(id),
+		ChannelName: channelName,
+		Sender:      sender,
+		Members:     sorted,
+		Key:         seed,
+		Created:     time.Now(),
+	}, seed, nil
+}
+
+// UseSendingChain store a distributed chain as the sending chain of its channel,
+// the sender is also a member so the chain is added to the receiving ones
+func UseSendingChain(c *Chain, seed []byte) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	current.Sending[c.ChannelName] = c
+	current.Receiving[c.ID] = &Chain{
+		ID:          c.ID,
+		ChannelName: c.ChannelName,
+		Sender:      c.Sender,
+		Key:         append([]byte{}, seed...),
+		Created:     c.Created,
+	}
+	return save()
+}
+
+// SendingChain return the sending chain of a channel if it can still be used
+// for these members, a new chain has to be created otherwise
+func SendingChain(channelName string, members []string) (c *Chain, ok bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	c, ok = current.Sending[channelName]
+	if !ok || !c.distributedTo(members) || c.Index >= ChainMaxMessages || time.Since(c.Created) > ChainLifetime {
+		return nil, false
+	}
+	return c, true
+}
+
+// Encrypt encrypt a message with the next key of the sending chain of a channel,
+// additionalData return the data to authenticate with the message
+func Encrypt(channelName string, plaintext []byte, additionalData func(chainID string, index uint32) []byte) (chainID string, index uint32, ciphertext []byte, err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	c, ok := current.Sending[channelName]
+	if !ok {
+		return "", 0, nil, fmt.Errorf("no sending chain for channel %q", channelName)
+	}
+	index = c.Index
+	if ciphertext, err = symmetric.Seal(c.step(), plaintext, additionalData(c.ID, index)); err != nil {
+		return "", 0, nil, err
+	}
+	return c.ID, index, ciphertext, save()
+}
+
+// AddReceivingChain store a chain created by another member
+func AddReceivingChain(c *Chain) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, exists := current.Receiving[c.ID]; exists {
+		return nil
+	}
+	current.Receiving[c.ID] = c
+	return save()
+}
+
+// HasReceivingChain return whether a chain is known
+func HasReceivingChain(chainID string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	_, ok := current.Receiving[chainID]
+	return ok
+}
+
+// Decrypt decrypt the message at index of a chain, the message key is destroyed
+// after use so the plaintext is kept in memory to display the message again;
+// it's never written to disk, the message can't be read after a restart
+func Decrypt(chainID string, sender string, index uint32, ciphertext []byte, additionalData []byte) (plaintext []byte, err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cacheKey := fmt.Sprintf("%s:%d", chainID, index)
+	if plaintext, ok := plaintexts[cacheKey]; ok {
+		return plaintext, nil
+	}
+
+	c, ok := current.Receiving[chainID]
+	if !ok {
+		return nil, fmt.Errorf("unknown chain %q", chainID)
+	}
+	if c.Sender != sender {
+		return nil, fmt.Errorf("chain %q doesn't belong to %q", chainID, sender)
+	}
+
+	// the chain only moves forward once the message is authenticated, a forged
+	// message must not destroy the keys of the messages not received yet
+	next := c.clone()
+	messageKey, err := next.messageKey(index)
+	if err != nil {
+		return nil, err
+	}
+	if plaintext, err = symmetric.Open(messageKey, ciphertext, additionalData); err != nil {
+		return nil, err
+	}
+	current.Receiving[chainID] = next
+	plaintexts[cacheKey] = plaintext
+	return plaintext, save()
+}
+
+// clone return a deep copy of the chain
+func (c *Chain) clone() *Chain {
+	cloned := *c
+	cloned.Key = append([]byte{}, c.Key...)
+	cloned.Members = append([]string{}, c.Members...)
+	cloned.Skipped = make(map[uint32][]byte, len(c.Skipped))
+	for index, key := range c.Skipped {
+		cloned.Skipped[index] = key
+	}
+	return &cloned
+}
+
+// messageKey return the key of the message at index, and destroy it
+func (c *Chain) messageKey(index uint32) (key []byte, err error) {
+	if index < c.Index {
+		key, ok := c.Skipped[index]
+		if !ok {
+			return nil, ErrConsumed
+		}
+		delete(c.Skipped, index)
+		return key, nil
+	}
+
+	if index-c.Index > maxSkipped {
+		return nil, fmt.Errorf("too many skipped messages in chain %q", c.ID)
+	}
+	if c.Skipped == nil {
+		c.Skipped = make(map[uint32][]byte)
+	}
+	for c.Index < index {
+		skippedIndex := c.Index
+		c.Skipped[skippedIndex] = c.step()
+	}
+	// keep the most recent skipped keys only
+	for i := range c.Skipped {
+		if index-i > maxSkipped {
+			delete(c.Skipped, i)
+		}
+	}
+	return c.step(), nil
+}
@@ -0,0 +1,140 @@
+package ratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// PrekeyLifetime is the duration after which a new prekey is published
+	PrekeyLifetime = 7 * 24 * time.Hour
+	// prekeyGrace is the duration a replaced prekey is kept to unwrap the
+	// chains created before the new prekey has been noticed by the others
+	prekeyGrace = 7 * 24 * time.Hour
+)
+
+// Prekey is a X25519 key pair, its public part is published in the
+// user profile and used by the others to wrap the chains they create
+type Prekey struct {
+	Private []byte    `json:"private"`
+	Public  []byte    `json:"public"`
+	Created time.Time `json:"created"`
+}
+
+// state is what is stored on disk between two runs
+type state struct {
+	Prekeys   []*Prekey         `json:"prekeys"`   // most recent first
+	Sending   map[string]*Chain `json:"sending"`   // our current chain, by channel name
+	Receiving map[string]*Chain `json:"receiving"` // chains we can decrypt, by chain id
+}
+
+var (
+	// Filepath is the path of the file where the ratchet state is saved,
+	// the state is only kept in memory when empty
+	Filepath string
+
+	current = newState()
+	mutex   sync.Mutex
+
+	// plaintexts of the messages decrypted during this run, as message
+	// keys are destroyed after use it's the only way to display them again
+	plaintexts = make(map[string][]byte)
+)
+
+func newState() *state {
+	return &state{
+		Sending:   make(map[string]*Chain),
+		Receiving: make(map[string]*Chain),
+	}
+}
+
+// Load read the ratchet state saved in filepath
+func Load(filepath string) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	Filepath = filepath
+	current = newState()
+	plaintexts = make(map[string][]byte)
+	if filepath == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(filepath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read file %q: %v", filepath, err)
+	}
+	if err = json.Unmarshal(raw, current); err != nil {
+		return fmt.Errorf("unable to parse json file: %v", err)
+	}
+	if current.Sending == nil {
+		current.Sending = make(map[string]*Chain)
+	}
+	if current.Receiving == nil {
+		current.Receiving = make(map[string]*Chain)
+	}
+	return nil
+}
+
+func save() (err error) {
+	if Filepath == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(current, "", "    ")
+	if err != nil {
+		return fmt.Errorf("unable to create json: %v", err)
+	}
+	if err = ioutil.WriteFile(Filepath, raw, 0600); err != nil {
+		return fmt.Errorf("unable to write ratchet state file %q: %v", Filepath, err)
+	}
+	return nil
+}
+
+// CurrentPrekey return the public prekey to publish, a new prekey is
+// generated when the current one is too old and expired ones are destroyed
+func CurrentPrekey() (public []byte, err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	now := time.Now()
+	if len(current.Prekeys) == 0 || now.Sub(current.Prekeys[0].Created) > PrekeyLifetime {
+		private, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate prekey: %v", err)
+		}
+		current.Prekeys = append([]*Prekey{{
+			Private: private.Bytes(),
+			Public:  private.PublicKey().Bytes(),
+			Created: now,
+		}}, current.Prekeys...)
+	}
+
+	// a prekey is expired once its successor has been published for a while
+	kept := current.Prekeys[:1]
+	for i := 1; i < len(current.Prekeys); i++ {
+		if now.Sub(current.Prekeys[i-1].Created) < prekeyGrace {
+			kept = append(kept, current.Prekeys[i])
+		}
+	}
+	current.Prekeys = kept
+
+	return current.Prekeys[0].Public, save()
+}
+
+// findPrekey return the private prekey matching a public prekey
+func findPrekey(public []byte) (private *ecdh.PrivateKey, err error) {
+	for _, pk := range current.Prekeys {
+		if string(pk.Public) == string(public) {
+			return ecdh.X25519().NewPrivateKey(pk.Private)
+		}
+	}
+	return nil, fmt.Errorf("prekey not found, it may have expired")
+}
@@ -0,0 +1,65 @@
+package ratchet
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/krostar/nebulo-client-desktop/symmetric"
+)
+
+// Wrapped is a chain seed encrypted for a member with a key agreed
+// between an ephemeral key and the member prekey
+type Wrapped struct {
+	Prekey     []byte `json:"prekey"`    // public prekey of the member
+	Ephemeral  []byte `json:"ephemeral"` // public ephemeral key of the sender
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Wrap encrypt a chain seed for the owner of prekey
+func Wrap(seed []byte, prekey []byte, additionalData []byte) (w *Wrapped, err error) {
+	peer, err := ecdh.X25519().NewPublicKey(prekey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prekey: %v", err)
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate ephemeral key: %v", err)
+	}
+	shared, err := ephemeral.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("unable to agree on a key: %v", err)
+	}
+
+	ciphertext, err := symmetric.Seal(kdf(shared, "wrap"), seed, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	// the ephemeral private key is dropped here, the seed can
+	// only be recovered with the prekey from now on
+	return &Wrapped{
+		Prekey:     prekey,
+		Ephemeral:  ephemeral.PublicKey().Bytes(),
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// Unwrap decrypt a chain seed wrapped for one of our prekeys
+func Unwrap(w *Wrapped, additionalData []byte) (seed []byte, err error) {
+	mutex.Lock()
+	private, err := findPrekey(w.Prekey)
+	mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := ecdh.X25519().NewPublicKey(w.Ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %v", err)
+	}
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("unable to agree on a key: %v", err)
+	}
+	return symmetric.Open(kdf(shared, "wrap"), w.Ciphertext, additionalData)
+}
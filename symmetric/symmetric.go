@@ -0,0 +1,64 @@
+package symmetric
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// KeySize is the size of the keys used to seal data (AES-256)
+const KeySize = 32
+
+// NewKey generate a random key
+func NewKey() (key []byte, err error) {
+	key = make([]byte, KeySize)
+	if _, err = rand.Read(key); err != nil {
+		return nil, fmt.Errorf("unable to generate key: %v", err)
+	}
+	return key, nil
+}
+
+// Seal encrypt and authenticate plaintext and additionalData with AES-GCM,
+// the random nonce is prepended to the ciphertext
+func Seal(key []byte, plaintext []byte, additionalData []byte) (ciphertext []byte, err error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %v", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypt and check the integrity of a ciphertext created by Seal
+func Open(key []byte, ciphertext []byte, additionalData []byte) (plaintext []byte, err error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err = gcm.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt: %v", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (gcm cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher: %v", err)
+	}
+	gcm, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create gcm: %v", err)
+	}
+	return gcm, nil
+}
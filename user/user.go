@@ -1,12 +1,14 @@
 package user
 
 import (
+	"sync"
 	"time"
 
 	"github.com/krostar/nebulo-golib/log"
 
 	"github.com/krostar/nebulo-client-desktop/config"
 	"github.com/krostar/nebulo-client-desktop/contact"
+	"github.com/krostar/nebulo-client-desktop/identity"
 )
 
 // User represent the informations we get from the API
@@ -17,35 +19,173 @@ type User struct {
 	LoginFirst         time.Time         `json:"login_first"`
 	LoginLast          time.Time         `json:"login_last"`
 	PublicKeyDerBase64 string            `json:"public_key_der_b64"`
+	Prekey             []byte            `json:"prekey,omitempty"`           // X25519 public key used to wrap ratchet chains
+	PrekeySignature    []byte            `json:"prekey_signature,omitempty"` // of the prekey, by the user
 	Contacts           []contact.Contact `json:"contacts"`
 }
 
-// Logged store the current logged user
-var Logged *User
+// publicKeys cache the parsed public keys by their base64 encoded DER,
+// to avoid decoding them on every message; users are copied around so
+// the cache is not kept in the users themselves. The contacts keys are
+// only sent to the server as is, they are never parsed and not cached
+var publicKeys sync.Map // of *parsedPublicKey
+
+// parsedPublicKey is a public key parsed once, by the first caller
+type parsedPublicKey struct {
+	once sync.Once
+	pkey *identity.PublicKey
+	err  error
+}
+
+// PublicKey return the parsed public key of the user, the key is parsed
+// once; it's safe to call it concurrently, the parsing of different keys
+// don't wait for each other
+func (u *User) PublicKey() (pkey *identity.PublicKey, err error) {
+	cached, _ := publicKeys.LoadOrStore(u.PublicKeyDerBase64, &parsedPublicKey{})
+	parsed := cached.(*parsedPublicKey)
+	parsed.once.Do(func() {
+		parsed.pkey, parsed.err = identity.ParsePublicKeyDERBase64(u.PublicKeyDerBase64)
+	})
+	return parsed.pkey, parsed.err
+}
+
+// forgetPublicKeys empty the public keys cache, the keys of the members
+// of the channels of a user are not needed by the next one
+func forgetPublicKeys() {
+	publicKeys.Range(func(key, _ interface{}) bool {
+		publicKeys.Delete(key)
+		return true
+	})
+}
+
+var (
+	// Logged store the current logged user
+	Logged *User
+	// loggedMutex guard Logged and its contacts, changed by the tasks
+	// and the configuration reload
+	loggedMutex sync.RWMutex
+)
+
+// IsLogged return whether a user is logged
+func IsLogged() bool {
+	loggedMutex.RLock()
+	defer loggedMutex.RUnlock()
+	return Logged != nil
+}
+
+// Contacts return a copy of the contacts of the logged user
+func Contacts() []contact.Contact {
+	loggedMutex.RLock()
+	defer loggedMutex.RUnlock()
+	if Logged == nil {
+		return nil
+	}
+	return append([]contact.Contact{}, Logged.Contacts...)
+}
 
 // Login is called when the login api call succeed
 func Login(u *User) (loggedUser *User, err error) {
-	if Logged != nil {
-		log.Warningln("user already Logged: %q, disconnect first", Logged.KeyFingerprint)
-		Logout()
-	}
 	log.Infof("login successful, Logged user: %q", u.KeyFingerprint)
-	if config.Config.Run.ContactsFile != "" {
-		u.Contacts, err = contact.LoadFromJSONFile(config.Config.Run.ContactsFile)
+	if contactsFile := config.Current().Run.ContactsFile; contactsFile != "" {
+		u.Contacts, err = contact.LoadFromJSONFile(contactsFile)
 		if err != nil {
-			log.Warningf("unable to load user contacts from %q: %v, user doesn't have contact yet", config.Config.Run.ContactsFile, err)
+			log.Warningf("unable to load user contacts from %q: %v, user doesn't have contact yet", contactsFile, err)
 		}
 	}
+
+	loggedMutex.Lock()
+	defer loggedMutex.Unlock()
+	if Logged != nil {
+		log.Warningf("user already Logged: %q, disconnect first", Logged.KeyFingerprint)
+		logout()
+	}
 	Logged = u
 	return Logged, nil
 }
 
+// OnConfigReloaded load the contacts of the logged user again when the contacts file changed
+func OnConfigReloaded(previous config.Options) {
+	contactsFile := config.Current().Run.ContactsFile
+	if !IsLogged() || contactsFile == previous.Run.ContactsFile {
+		return
+	}
+	contacts, err := contact.LoadFromJSONFile(contactsFile)
+	if err != nil {
+		log.Warningf("unable to load user contacts from %q: %v, the previous contacts are kept", contactsFile, err)
+		return
+	}
+	loggedMutex.Lock()
+	defer loggedMutex.Unlock()
+	if Logged != nil {
+		Logged.Contacts = contacts
+	}
+}
+
 // Logout is called when user need to be disconnected
 func Logout() {
+	loggedMutex.Lock()
+	defer loggedMutex.Unlock()
+	logout()
+}
+
+// logout disconnect the logged user, loggedMutex must be locked
+func logout() {
 	if Logged != nil {
 		log.Infoln("logout user %q", Logged.KeyFingerprint)
-		config.Config.Run.TLS.Key = ""
-		config.Config.Run.TLS.KeyPassword = ""
+		config.Update(ForgetKey)
 		Logged = nil
+		forgetPublicKeys()
+	}
+}
+
+// ForgetKey remove the private key of the identity from the configuration
+func ForgetKey(c *config.Options) {
+	c.Run.TLS.Key = ""
+	c.Run.TLS.KeyPassword = ""
+}
+
+// PrivateKey load the private key of the logged user
+func PrivateKey() (pKey *identity.PrivateKey, err error) {
+	id, err := identity.Load(IdentitySource(&config.Current().Run.TLS))
+	if err != nil {
+		return nil, err
+	}
+	return id.Key, nil
+}
+
+// IdentitySource return where to find the identity described by the tls options
+func IdentitySource(tlsOptions *config.TLSOptions) identity.Source {
+	source := identity.Source{
+		Store:    identity.Store(tlsOptions.Store),
+		Cert:     tlsOptions.Cert,
+		Key:      tlsOptions.Key,
+		Password: tlsOptions.KeyPassword,
+	}
+	if source.Store == identity.StorePKCS11 {
+		source.Key = tlsOptions.PKCS11.KeyLabel
+		source.Module = tlsOptions.PKCS11.Module
+		source.TokenLabel = tlsOptions.PKCS11.TokenLabel
+	}
+	return source
+}
+
+// SetIdentitySource change the tls options to use the identity of source
+func SetIdentitySource(tlsOptions *config.TLSOptions, source identity.Source) {
+	tlsOptions.Store = string(source.Store)
+	tlsOptions.KeyPassword = source.Password
+	if source.Cert != "" {
+		tlsOptions.Cert = source.Cert
+	}
+	tlsOptions.Key = ""
+	switch source.Store {
+	case identity.StorePKCS11:
+		tlsOptions.PKCS11 = config.PKCS11Options{
+			Module:     source.Module,
+			TokenLabel: source.TokenLabel,
+			KeyLabel:   source.Key,
+		}
+	case identity.StorePKCS12: // the key is in the bundle
+	default:
+		tlsOptions.Key = source.Key
 	}
 }
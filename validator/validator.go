@@ -1,7 +1,12 @@
 package validator
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	gvalidator "github.com/krostar/nebulo-golib/tools/validator"
 	validator "gopkg.in/validator.v2"
@@ -16,4 +21,82 @@ func init() {
 	if err = validator.SetValidationFunc("string", gvalidator.String); err != nil {
 		panic(fmt.Errorf("unable to set validation function %q: %v", "string", err))
 	}
+	if err = validator.SetValidationFunc("duration", Duration); err != nil {
+		panic(fmt.Errorf("unable to set validation function %q: %v", "duration", err))
+	}
+	if err = validator.SetValidationFunc("pins", Pins); err != nil {
+		panic(fmt.Errorf("unable to set validation function %q: %v", "pins", err))
+	}
+	if err = validator.SetValidationFunc("proxy", Proxy); err != nil {
+		panic(fmt.Errorf("unable to set validation function %q: %v", "proxy", err))
+	}
+}
+
+// Duration check that a string is empty or a positive duration understood by time.ParseDuration
+func Duration(v interface{}, _ string) error {
+	value, ok := v.(string)
+	if !ok {
+		return validator.ErrUnsupported
+	}
+	if value == "" {
+		return nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %v", value, err)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("duration %q must be positive", value)
+	}
+	return nil
+}
+
+// Pins check that a string is a comma separated list of "sha256/" prefixed
+// base64 encoded sha256 hashes
+func Pins(v interface{}, _ string) error {
+	value, ok := v.(string)
+	if !ok {
+		return validator.ErrUnsupported
+	}
+	for _, pin := range strings.Split(value, ",") {
+		if pin = strings.TrimSpace(pin); pin == "" {
+			continue
+		}
+		if !strings.HasPrefix(pin, "sha256/") {
+			return fmt.Errorf("pin %q must start with sha256/", pin)
+		}
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil {
+			return fmt.Errorf("invalid pin %q: %v", pin, err)
+		}
+		if len(hash) != sha256.Size {
+			return fmt.Errorf("invalid pin %q: expected %d bytes hash, got %d", pin, sha256.Size, len(hash))
+		}
+	}
+	return nil
+}
+
+// Proxy check that a string is empty, "tor", "environment" or a proxy url
+// with an http, https or socks5 scheme
+func Proxy(v interface{}, _ string) error {
+	value, ok := v.(string)
+	if !ok {
+		return validator.ErrUnsupported
+	}
+	if value == "" || value == "tor" || value == "environment" {
+		return nil
+	}
+	proxyURL, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid proxy url %q: %v", value, err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return fmt.Errorf("proxy url %q has no host", value)
+	}
+	return nil
 }